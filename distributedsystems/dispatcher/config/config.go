@@ -9,18 +9,75 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/ratelimit"
 )
 
 type Config struct {
-	Environment          string `json:"ENVIRONMENT"`
-	Auth0Domain          string `json:"AUTH0_DOMAIN"`
-	DispatcherClientID   string `json:"DISPATCHER_AUTH0_CLIENT_ID"`
-	DispatcherClientSecret string `json:"DISPATCHER_AUTH0_CLIENT_SECRET"`
-	RabbitMQHost         string `json:"RABBITMQ_HOST"`
+	Environment            string             `json:"ENVIRONMENT"`
+	Auth0Domain            string             `json:"AUTH0_DOMAIN"`
+	DispatcherClientID     string             `json:"DISPATCHER_AUTH0_CLIENT_ID"`
+	DispatcherClientSecret string             `json:"DISPATCHER_AUTH0_CLIENT_SECRET"`
+	RabbitMQHost           string             `json:"RABBITMQ_HOST"`
+	QuorumPolicy           string             `json:"QUORUM_POLICY"`
+	QuorumOperatorWeights  map[string]float64 `json:"QUORUM_OPERATOR_WEIGHTS"`
+	// OperatorPublicKeys maps operator IDs to base64 encoded ed25519 public keys
+	OperatorPublicKeys map[string]string `json:"OPERATOR_PUBLIC_KEYS"`
+	// DatabaseURL is the Postgres DSN for the transaction ledger. Transactions
+	// are only kept in memory when it is empty.
+	DatabaseURL string `json:"DATABASE_URL"`
+	// MaxTransactionValue rejects transactions above this value, 0 disables the check
+	MaxTransactionValue int64 `json:"MAX_TRANSACTION_VALUE"`
+	// OAuth2 client credentials for RabbitMQ. Auth0 at AUTH0_DOMAIN is used
+	// when neither an issuer nor a token URL is set; an issuer alone has its
+	// token endpoint discovered.
+	OAuth2IssuerURL string `json:"OAUTH2_ISSUER_URL"`
+	OAuth2TokenURL  string `json:"OAUTH2_TOKEN_URL"`
+	// OAuth2AuthStyle is client_secret_post, client_secret_basic or private_key_jwt
+	OAuth2AuthStyle string `json:"OAUTH2_AUTH_STYLE"`
+	OAuth2Audience  string `json:"OAUTH2_AUDIENCE"`
+	// OAuth2Scopes is a space separated list of scopes to request
+	OAuth2Scopes string `json:"OAUTH2_SCOPES"`
+	// OAuth2PrivateKey is a PEM encoded key that signs private_key_jwt assertions
+	OAuth2PrivateKey string `json:"OAUTH2_PRIVATE_KEY"`
+	OAuth2KeyID      string `json:"OAUTH2_KEY_ID"`
+	// JWT settings for the API. Callers must present a bearer token from
+	// JWTIssuer, signed by a key at JWTJWKSURL (discovered from the issuer when
+	// empty), for JWTAudience. The issuer and audience are required unless
+	// both the issuer and JWKS URL are empty, when requests are not
+	// authenticated.
+	JWTIssuer   string `json:"JWT_ISSUER"`
+	JWTJWKSURL  string `json:"JWT_JWKS_URL"`
+	JWTAudience string `json:"JWT_AUDIENCE"`
+	// RateLimitTiers maps a tier name to its limit, e.g.
+	// {"default":{"rate":2,"burst":10},"partner":{"rate":50,"burst":100}}
+	RateLimitTiers map[string]ratelimit.Limit `json:"RATE_LIMIT_TIERS"`
+	// RateLimitClients assigns clients to tiers by key: sub:<jwt subject>,
+	// key:<API key name> or ip:<address>
+	RateLimitClients map[string]string `json:"RATE_LIMIT_CLIENTS"`
+	// RateLimitRedisURL shares rate limits between replicas when set
+	RateLimitRedisURL string `json:"RATE_LIMIT_REDIS_URL"`
+	// RateLimitIdleTTL is how long an in-memory client bucket is kept unused
+	RateLimitIdleTTL string `json:"RATE_LIMIT_IDLE_TTL"`
+	// TracesExporter is none, stdout or otlp
+	TracesExporter string `json:"OTEL_TRACES_EXPORTER"`
+	// OTLPEndpoint is the collector's OTLP/HTTP URL, e.g. http://localhost:4318
+	OTLPEndpoint string `json:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// RabbitMQPublishChannels caps how many channels publish at once
+	RabbitMQPublishChannels int `json:"RABBITMQ_PUBLISH_CHANNELS"`
+	// QuorumPolicyOverrides lists the policies a request may ask for with
+	// ?quorum=, e.g. ["unanimous:5"]. Overrides are refused when empty.
+	QuorumPolicyOverrides []string `json:"QUORUM_POLICY_OVERRIDES"`
+	// RateLimitAPIKeys maps a name to an API key, e.g. {"partner-1":"..."}.
+	// Callers sending a listed key in X-API-Key are rate limited as
+	// key:<name>; any other X-API-Key is ignored.
+	RateLimitAPIKeys map[string]string `json:"RATE_LIMIT_API_KEYS"`
+	// InsecureSkipVoteVerification counts votes without checking their
+	// signatures when OPERATOR_PUBLIC_KEYS is empty. Local development only.
+	InsecureSkipVoteVerification bool `json:"INSECURE_SKIP_VOTE_VERIFICATION"`
 	// Add any other configuration fields you need
 }
 
-
 func LoadConfig(ctx context.Context) (*Config, error) {
 	env := os.Getenv("ENVIRONMENT")
 	if env == "" {
@@ -64,4 +121,4 @@ func LoadConfig(ctx context.Context) (*Config, error) {
 	config.Environment = env
 
 	return &config, nil
-}
\ No newline at end of file
+}
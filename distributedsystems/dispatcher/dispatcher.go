@@ -2,93 +2,311 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"log"
-	"net/http"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
-	"golang.org/x/time/rate"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/config"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/health"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/ledger"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/quorum"
 	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/rabbitmq"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/ratelimit"
 	"github.com/rasha-hantash/golang/distributedsystems/libs/auth"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/correlation"
 	"github.com/rasha-hantash/golang/distributedsystems/libs/logger"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/tracing"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/vote"
 )
 
+// shutdownTimeout bounds how long in-flight transactions are given to finish
+// on shutdown. It leaves room for a full response window.
+const shutdownTimeout = 15 * time.Second
 
+// unreadyDelay is how long readiness fails on shutdown before the listener
+// closes, so load balancers stop sending requests first.
+const unreadyDelay = 5 * time.Second
 
 type Server struct {
-	rmqSvc *rabbitmq.RabbitMQService
-	router       *mux.Router
-	limiter      *rate.Limiter
+	rmqSvc  *rabbitmq.RabbitMQService
+	router  *mux.Router
+	limiter *ratelimit.Limiter
+	// apiKeys maps the SHA-256 of each known X-API-Key to its name.
+	apiKeys map[[sha256.Size]byte]string
+	// verifier checks the bearer tokens on API requests. Requests are not
+	// authenticated when it is nil.
+	verifier *auth.Verifier
+	// shuttingDown fails readiness once shutdown has begun.
+	shuttingDown atomic.Bool
 }
 
-
-
 func main() {
-	// load configuration 
+	// load configuration
 	h := &logger.ContextHandler{Handler: slog.NewJSONHandler(os.Stdout, nil)}
 	slog.SetDefault(slog.New(h))
-    ctx := logger.AppendCtx(context.Background(), slog.String("request_id", "req-123"))
+	ctx := logger.AppendCtx(context.Background(), slog.String("service", "dispatcher"))
 
 	cfg, err := config.LoadConfig(ctx)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName:  "dispatcher",
+		Exporter:     cfg.TracesExporter,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	operatorKeys, err := vote.ParsePublicKeys(cfg.OperatorPublicKeys)
+	if err != nil {
+		log.Fatalf("Invalid operator public keys: %v", err)
+	}
+	if len(operatorKeys) == 0 {
+		if !cfg.InsecureSkipVoteVerification {
+			log.Fatalf("OPERATOR_PUBLIC_KEYS must be set to verify votes")
+		}
+		slog.WarnContext(ctx, "no operator public keys configured, vote signatures will not be verified")
+	}
+
+	policy := quorum.Default(len(operatorKeys))
+	if cfg.QuorumPolicy != "" {
+		policy, err = quorum.Parse(cfg.QuorumPolicy, len(operatorKeys), cfg.QuorumOperatorWeights)
+		if err != nil {
+			log.Fatalf("Invalid quorum policy: %v", err)
+		}
+	}
+	var policyOverrides []quorum.Policy
+	for _, spec := range cfg.QuorumPolicyOverrides {
+		override, err := quorum.Parse(spec, len(operatorKeys), cfg.QuorumOperatorWeights)
+		if err != nil {
+			log.Fatalf("Invalid quorum policy override: %v", err)
+		}
+		policyOverrides = append(policyOverrides, override)
+	}
+
+	var txnLedger ledger.Ledger
+	if cfg.DatabaseURL != "" {
+		pgLedger, err := ledger.NewPostgresLedger(ctx, cfg.DatabaseURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to ledger database: %v", err)
+		}
+		defer pgLedger.Close()
+		txnLedger = pgLedger
+	} else {
+		slog.WarnContext(ctx, "DATABASE_URL not set, transactions will only be kept in memory")
+		txnLedger = ledger.NewMemoryLedger()
+	}
+
+	audience := cfg.OAuth2Audience
+	if audience == "" {
+		audience = "rabbitmq"
+	}
+	credentials, err := auth.Settings{
+		Auth0Domain:  cfg.Auth0Domain,
+		IssuerURL:    cfg.OAuth2IssuerURL,
+		TokenURL:     cfg.OAuth2TokenURL,
+		ClientID:     cfg.DispatcherClientID,
+		ClientSecret: cfg.DispatcherClientSecret,
+		Audience:     audience,
+		Scopes:       cfg.OAuth2Scopes,
+		AuthStyle:    cfg.OAuth2AuthStyle,
+		PrivateKey:   cfg.OAuth2PrivateKey,
+		KeyID:        cfg.OAuth2KeyID,
+	}.ClientCredentials()
+	if err != nil {
+		log.Fatalf("Invalid OAuth2 client credentials: %v", err)
+	}
+
 	// Initialize RabbitMQ connection
 	rabbitCfg := rabbitmq.RabbitMQConfig{
-		Host: cfg.RabbitMQHost,
-		Port: "5672", // Assuming default port, adjust if needed
-		Auth0Config: auth.Auth0Config{
-			Domain:       cfg.Auth0Domain,
-			ClientID:     cfg.DispatcherClientID,
-			ClientSecret: cfg.DispatcherClientSecret,
-			Audience:     "rabbitmq",
-		},
-	}
-	rabbitmqSvc, err := rabbitmq.NewConnection(rabbitCfg)
+		Host:                cfg.RabbitMQHost,
+		Port:                "5672", // Assuming default port, adjust if needed
+		Credentials:         credentials,
+		QuorumPolicy:        policy,
+		QuorumOverrides:     policyOverrides,
+		OperatorWeights:     cfg.QuorumOperatorWeights,
+		OperatorKeys:        operatorKeys,
+		UnsignedVotes:       cfg.InsecureSkipVoteVerification,
+		Ledger:              txnLedger,
+		MaxTransactionValue: cfg.MaxTransactionValue,
+		PublishChannels:     cfg.RabbitMQPublishChannels,
+	}
+	rabbitmqSvc, err := rabbitmq.NewConnection(ctx, rabbitCfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
 	}
 	defer rabbitmqSvc.Close()
 
-	s := NewServer(rabbitmqSvc)
-	port := "80" // todo maybe put this in an env var? 
-	slog.InfoContext(ctx, "server is now listening", slog.String("port", port))
-	log.Fatal(http.ListenAndServe(":"+port, s.router)) // todo add host no? 
-}
+	verifier, err := newVerifier(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up JWT authentication: %v", err)
+	}
+	if verifier == nil {
+		slog.WarnContext(ctx, "JWT_ISSUER and JWT_JWKS_URL not set, API requests will not be authenticated")
+	}
+
+	limiter, err := newLimiter(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up rate limiting: %v", err)
+	}
+
+	s := NewServer(rabbitmqSvc, verifier, limiter, cfg.RateLimitAPIKeys)
+	port := "80" // todo maybe put this in an env var?
+	// todo add host no?
+	srv := &http.Server{Addr: ":" + port, Handler: s.router}
+
+	// Stop on SIGTERM/SIGINT. The RabbitMQ connection runs on ctx rather than
+	// the signal context so it stays up while in-flight transactions finish.
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
+	go func() {
+		slog.InfoContext(ctx, "server is now listening", slog.String("port", port))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	<-sigCtx.Done()
+	s.shuttingDown.Store(true)
+	slog.InfoContext(ctx, "shutting down, failing readiness before closing the listener", "delay", unreadyDelay.String())
+	time.Sleep(unreadyDelay)
+	slog.InfoContext(ctx, "shutting down, waiting for in-flight transactions", "timeout", shutdownTimeout.String())
 
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	// Shutdown stops accepting connections and waits for in-flight requests,
+	// including synchronous broadcasts still collecting responses.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.WarnContext(ctx, "timed out waiting for HTTP requests to finish", "error", err.Error())
+	}
+	// Async broadcasts and callbacks outlive their requests.
+	if err := rabbitmqSvc.Drain(shutdownCtx); err != nil {
+		slog.WarnContext(ctx, "timed out waiting for background broadcasts to finish", "error", err.Error())
+	}
+	slog.InfoContext(ctx, "shut down")
+}
 
-func NewServer(rabbitmqSvc *rabbitmq.RabbitMQService) *Server {
+// NewServer routes the API. apiKeys maps names to the API keys callers may
+// identify themselves with for rate limiting.
+func NewServer(rabbitmqSvc *rabbitmq.RabbitMQService, verifier *auth.Verifier, limiter *ratelimit.Limiter, apiKeys map[string]string) *Server {
 	s := &Server{
-		rmqSvc: rabbitmqSvc,
-		router:       mux.NewRouter(),
-		limiter:      rate.NewLimiter(rate.Limit(2), 10),
+		rmqSvc:   rabbitmqSvc,
+		router:   mux.NewRouter(),
+		limiter:  limiter,
+		apiKeys:  make(map[[sha256.Size]byte]string, len(apiKeys)),
+		verifier: verifier,
+	}
+	for name, key := range apiKeys {
+		s.apiKeys[sha256.Sum256([]byte(key))] = name
 	}
 
-	s.router.Use(s.rateLimiterMiddleware)
+	s.router.Use(tracingMiddleware, metricsMiddleware, correlation.Middleware)
 	s.router.HandleFunc("/health", s.healthCheckHandler).Methods("GET")
-	s.router.HandleFunc("/transaction", s.rmqSvc.BroadcastTransaction).Methods("POST")
+	// Liveness only says the process is serving requests; restarting it
+	// won't fix a broker outage, so dependencies are only in readiness.
+	s.router.Handle("/livez", health.Handler(nil)).Methods("GET")
+	s.router.Handle("/readyz", health.Handler(map[string]health.Checker{
+		"amqp_connection":   s.rmqSvc.CheckConnection,
+		"exchange":          s.rmqSvc.CheckExchange,
+		"rabbitmq_token":    s.rmqSvc.CheckToken,
+		"operator_activity": s.rmqSvc.CheckOperatorActivity,
+		"shutdown":          s.checkShutdown,
+	})).Methods("GET")
+	s.router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	// Callers are limited by their subject once authenticated. Requests are
+	// also limited by IP before authentication, so failed attempts can't
+	// guess tokens unchecked.
+	api := s.router.NewRoute().Subrouter()
+	if verifier != nil {
+		api.Use(s.rateLimiter(ipKey))
+	}
+	api.Use(s.authMiddleware, s.rateLimiter(s.clientKey))
+	api.Handle("/transaction", s.requireScope(scopeSubmitTransactions, s.rmqSvc.BroadcastTransaction)).Methods("POST")
+	api.Handle("/transaction/{id}", s.requireScope(scopeReadTransactions, s.rmqSvc.GetTransaction)).Methods("GET")
 	return s
 }
 
+// newVerifier builds the bearer token verifier from the JWT settings. The
+// JWKS URL is discovered from the issuer when it isn't set. It returns nil
+// when neither is configured.
+func newVerifier(ctx context.Context, cfg *config.Config) (*auth.Verifier, error) {
+	if cfg.JWTIssuer == "" && cfg.JWTJWKSURL == "" {
+		return nil, nil
+	}
+	if cfg.JWTIssuer == "" {
+		return nil, fmt.Errorf("JWT_ISSUER must be set when JWT authentication is enabled")
+	}
+	if cfg.JWTAudience == "" {
+		return nil, fmt.Errorf("JWT_AUDIENCE must be set when JWT authentication is enabled")
+	}
+
+	jwksURL := cfg.JWTJWKSURL
+	if jwksURL == "" {
+		var err error
+		jwksURL, err = auth.DiscoverJWKSURL(ctx, cfg.JWTIssuer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	jwks, err := auth.NewJWKS(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewVerifier(jwks, cfg.JWTIssuer, cfg.JWTAudience)
+}
+
+// checkShutdown fails once the dispatcher has started shutting down.
+func (s *Server) checkShutdown(ctx context.Context) health.Check {
+	if s.shuttingDown.Load() {
+		return health.Fail("shutting down")
+	}
+	return health.Pass("")
+}
+
 func (s *Server) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	slog.InfoContext(r.Context(), "health check")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
-func (s *Server) rateLimiterMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !s.limiter.Allow() {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
-			return
+// newLimiter builds the per-client rate limiter. Buckets are shared through
+// Redis when RATE_LIMIT_REDIS_URL is set and kept in memory otherwise.
+func newLimiter(ctx context.Context, cfg *config.Config) (*ratelimit.Limiter, error) {
+	var store ratelimit.Store
+	if cfg.RateLimitRedisURL != "" {
+		redisStore, err := ratelimit.NewRedisStore(ctx, cfg.RateLimitRedisURL)
+		if err != nil {
+			return nil, err
 		}
-		next.ServeHTTP(w, r)
-	})
+		store = redisStore
+	} else {
+		idleTTL := 10 * time.Minute
+		if cfg.RateLimitIdleTTL != "" {
+			var err error
+			idleTTL, err = time.ParseDuration(cfg.RateLimitIdleTTL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate limit idle ttl: %w", err)
+			}
+		}
+		store = ratelimit.NewMemoryStore(ctx, idleTTL)
+	}
+	return ratelimit.New(store, cfg.RateLimitTiers, cfg.RateLimitClients)
 }
-
-
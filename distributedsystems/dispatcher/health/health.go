@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	// StatusWarn is reported but doesn't make the service unready.
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Check is the result of one health check.
+type Check struct {
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func Pass(detail string) Check { return Check{Status: StatusPass, Detail: detail} }
+func Warn(detail string) Check { return Check{Status: StatusWarn, Detail: detail} }
+func Fail(detail string) Check { return Check{Status: StatusFail, Detail: detail} }
+
+// Checker runs one check. It should return promptly once ctx is done.
+type Checker func(ctx context.Context) Check
+
+// Report is the overall status and the result of every check.
+type Report struct {
+	Status Status           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// checkTimeout bounds a single check so one slow dependency can't hold up
+// the load balancer's probe.
+const checkTimeout = 2 * time.Second
+
+// Run runs the checks concurrently. The report fails if any check fails.
+func Run(ctx context.Context, checks map[string]Checker) Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{Status: StatusPass, Checks: make(map[string]Check, len(checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			switch {
+			case result.Status == StatusFail:
+				report.Status = StatusFail
+			case result.Status == StatusWarn && report.Status == StatusPass:
+				report.Status = StatusWarn
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// Handler serves a JSON report of the checks: 200 when they pass or only
+// warn, 503 when any fails.
+func Handler(checks map[string]Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), checks)
+
+		code := http.StatusOK
+		if report.Status == StatusFail {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	}
+}
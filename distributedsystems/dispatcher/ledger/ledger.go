@@ -0,0 +1,68 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+)
+
+var (
+	ErrNotFound  = errors.New("transaction not found")
+	ErrDuplicate = errors.New("transaction with this idempotency key already exists")
+)
+
+// Transaction is a transaction request as recorded by the dispatcher, along
+// with the verdict once one has been reached.
+type Transaction struct {
+	ID             string     `json:"transaction_id"`
+	IdempotencyKey string     `json:"idempotency_key,omitempty"`
+	TxnHash        string     `json:"txn_hash"`
+	From           string     `json:"from"`
+	To             string     `json:"to"`
+	Value          int64      `json:"value"`
+	QuorumPolicy   string     `json:"quorum_policy"`
+	Status         string     `json:"status"`
+	IsCompliant    *bool      `json:"is_compliant"`
+	DecidedBy      string     `json:"decided_by,omitempty"`
+	ValidVotes     int        `json:"valid_votes"`
+	InvalidVotes   int        `json:"invalid_votes"`
+	MissingVotes   int        `json:"missing_votes"`
+	ElapsedMS      int64      `json:"elapsed_ms"`
+	Votes          []Vote     `json:"votes"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+type Vote struct {
+	OperatorID  string    `json:"operator_id"`
+	IsValid     bool      `json:"is_valid"`
+	Reason      string    `json:"reason,omitempty"`
+	ListVersion string    `json:"list_version,omitempty"`
+	Signature   []byte    `json:"signature,omitempty"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// Ledger records every transaction the dispatcher broadcasts, the votes it
+// receives and the final verdict.
+type Ledger interface {
+	// CreateTransaction records a new pending transaction. It returns
+	// ErrDuplicate if a transaction with the same idempotency key exists.
+	CreateTransaction(ctx context.Context, txn Transaction) error
+	// RecordVote records an operator's vote on a transaction.
+	RecordVote(ctx context.Context, transactionID string, vote Vote) error
+	// CompleteTransaction records the verdict fields of txn and marks it completed.
+	CompleteTransaction(ctx context.Context, txn Transaction) error
+	// GetTransaction returns a transaction and its votes, or ErrNotFound.
+	GetTransaction(ctx context.Context, id string) (*Transaction, error)
+	// FindByIdempotencyKey returns the transaction submitted with key, or
+	// ErrNotFound.
+	FindByIdempotencyKey(ctx context.Context, key string) (*Transaction, error)
+	// ReleaseIdempotencyKey detaches a transaction from its idempotency key so
+	// the key can be submitted again.
+	ReleaseIdempotencyKey(ctx context.Context, id string) error
+}
@@ -0,0 +1,116 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryLedger keeps transactions in memory. It is meant for local runs
+// without a database; everything is lost when the process exits.
+type MemoryLedger struct {
+	mu           sync.RWMutex
+	transactions map[string]*Transaction
+	// byKey maps idempotency keys to transaction IDs
+	byKey map[string]string
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		transactions: make(map[string]*Transaction),
+		byKey:        make(map[string]string),
+	}
+}
+
+func (l *MemoryLedger) CreateTransaction(ctx context.Context, txn Transaction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if txn.IdempotencyKey != "" {
+		if _, ok := l.byKey[txn.IdempotencyKey]; ok {
+			return ErrDuplicate
+		}
+		l.byKey[txn.IdempotencyKey] = txn.ID
+	}
+
+	txn.Status = StatusPending
+	txn.Votes = []Vote{}
+	l.transactions[txn.ID] = &txn
+	return nil
+}
+
+func (l *MemoryLedger) RecordVote(ctx context.Context, transactionID string, vote Vote) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	txn, ok := l.transactions[transactionID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, v := range txn.Votes {
+		if v.OperatorID == vote.OperatorID {
+			return nil
+		}
+	}
+	txn.Votes = append(txn.Votes, vote)
+	return nil
+}
+
+func (l *MemoryLedger) CompleteTransaction(ctx context.Context, txn Transaction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stored, ok := l.transactions[txn.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status = StatusCompleted
+	stored.IsCompliant = txn.IsCompliant
+	stored.DecidedBy = txn.DecidedBy
+	stored.ValidVotes = txn.ValidVotes
+	stored.InvalidVotes = txn.InvalidVotes
+	stored.MissingVotes = txn.MissingVotes
+	stored.ElapsedMS = txn.ElapsedMS
+	stored.CompletedAt = txn.CompletedAt
+	return nil
+}
+
+func (l *MemoryLedger) ReleaseIdempotencyKey(ctx context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	txn, ok := l.transactions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(l.byKey, txn.IdempotencyKey)
+	txn.IdempotencyKey = ""
+	return nil
+}
+
+func (l *MemoryLedger) GetTransaction(ctx context.Context, id string) (*Transaction, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.get(id)
+}
+
+func (l *MemoryLedger) FindByIdempotencyKey(ctx context.Context, key string) (*Transaction, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	id, ok := l.byKey[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return l.get(id)
+}
+
+func (l *MemoryLedger) get(id string) (*Transaction, error) {
+	txn, ok := l.transactions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *txn
+	cp.Votes = append([]Vote{}, txn.Votes...)
+	return &cp, nil
+}
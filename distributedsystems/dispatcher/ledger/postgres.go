@@ -0,0 +1,158 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+type PostgresLedger struct {
+	db *sql.DB
+}
+
+// NewPostgresLedger opens a connection to the database at dsn. The schema is
+// managed by the migrations in sql/migrations.
+func NewPostgresLedger(ctx context.Context, dsn string) (*PostgresLedger, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return &PostgresLedger{db: db}, nil
+}
+
+func (l *PostgresLedger) CreateTransaction(ctx context.Context, txn Transaction) error {
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO transactions (id, idempotency_key, txn_hash, from_address, to_address, value, quorum_policy, status, created_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9)`,
+		txn.ID, txn.IdempotencyKey, txn.TxnHash, txn.From, txn.To, txn.Value, txn.QuorumPolicy, StatusPending, txn.CreatedAt,
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert transaction: %w", err)
+	}
+	return nil
+}
+
+func (l *PostgresLedger) RecordVote(ctx context.Context, transactionID string, vote Vote) error {
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO transaction_votes (transaction_id, operator_id, is_valid, reason, list_version, signature, received_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7)
+		ON CONFLICT (transaction_id, operator_id) DO NOTHING`,
+		transactionID, vote.OperatorID, vote.IsValid, vote.Reason, vote.ListVersion, vote.Signature, vote.ReceivedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert vote: %w", err)
+	}
+	return nil
+}
+
+func (l *PostgresLedger) CompleteTransaction(ctx context.Context, txn Transaction) error {
+	res, err := l.db.ExecContext(ctx, `
+		UPDATE transactions
+		SET status = $2, is_compliant = $3, decided_by = $4, valid_votes = $5,
+			invalid_votes = $6, missing_votes = $7, elapsed_ms = $8, completed_at = $9
+		WHERE id = $1`,
+		txn.ID, StatusCompleted, txn.IsCompliant, txn.DecidedBy, txn.ValidVotes,
+		txn.InvalidVotes, txn.MissingVotes, txn.ElapsedMS, txn.CompletedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (l *PostgresLedger) ReleaseIdempotencyKey(ctx context.Context, id string) error {
+	_, err := l.db.ExecContext(ctx, `UPDATE transactions SET idempotency_key = NULL WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (l *PostgresLedger) GetTransaction(ctx context.Context, id string) (*Transaction, error) {
+	return l.getTransaction(ctx, "id", id)
+}
+
+func (l *PostgresLedger) FindByIdempotencyKey(ctx context.Context, key string) (*Transaction, error) {
+	return l.getTransaction(ctx, "idempotency_key", key)
+}
+
+// getTransaction loads a transaction by column, which must be id or
+// idempotency_key.
+func (l *PostgresLedger) getTransaction(ctx context.Context, column, value string) (*Transaction, error) {
+	var (
+		txn            Transaction
+		idempotencyKey sql.NullString
+		isCompliant    sql.NullBool
+		decidedBy      sql.NullString
+		completedAt    sql.NullTime
+	)
+	err := l.db.QueryRowContext(ctx, `
+		SELECT id, idempotency_key, txn_hash, from_address, to_address, value, quorum_policy, status, is_compliant,
+			decided_by, valid_votes, invalid_votes, missing_votes, elapsed_ms, created_at, completed_at
+		FROM transactions
+		WHERE `+column+` = $1`, value,
+	).Scan(
+		&txn.ID, &idempotencyKey, &txn.TxnHash, &txn.From, &txn.To, &txn.Value, &txn.QuorumPolicy, &txn.Status, &isCompliant,
+		&decidedBy, &txn.ValidVotes, &txn.InvalidVotes, &txn.MissingVotes, &txn.ElapsedMS, &txn.CreatedAt, &completedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transaction: %w", err)
+	}
+
+	txn.IdempotencyKey = idempotencyKey.String
+	if isCompliant.Valid {
+		txn.IsCompliant = &isCompliant.Bool
+	}
+	txn.DecidedBy = decidedBy.String
+	if completedAt.Valid {
+		txn.CompletedAt = &completedAt.Time
+	}
+
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT operator_id, is_valid, COALESCE(reason, ''), COALESCE(list_version, ''), signature, received_at
+		FROM transaction_votes
+		WHERE transaction_id = $1
+		ORDER BY received_at`, txn.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query votes: %w", err)
+	}
+	defer rows.Close()
+
+	txn.Votes = []Vote{}
+	for rows.Next() {
+		var v Vote
+		if err := rows.Scan(&v.OperatorID, &v.IsValid, &v.Reason, &v.ListVersion, &v.Signature, &v.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan vote: %w", err)
+		}
+		txn.Votes = append(txn.Votes, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read votes: %w", err)
+	}
+
+	return &txn, nil
+}
+
+func (l *PostgresLedger) Close() error {
+	return l.db.Close()
+}
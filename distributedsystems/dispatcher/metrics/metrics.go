@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dispatcher_http_requests_total",
+		Help: "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dispatcher_http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// Transactions counts broadcast transactions by outcome: approved,
+	// rejected, timeout, error or unavailable.
+	Transactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dispatcher_transactions_total",
+		Help: "Broadcast transactions, by outcome.",
+	}, []string{"outcome"})
+
+	QuorumDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "dispatcher_quorum_duration_seconds",
+		Help: "Time from receiving a transaction to reaching a verdict, by what decided it.",
+		// The response window is 5s, so anything past it is a timeout.
+		Buckets: []float64{.05, .1, .25, .5, .75, 1, 1.5, 2, 3, 4, 5, 6},
+	}, []string{"decided_by"})
+
+	VotesPerTransaction = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dispatcher_votes_per_transaction",
+		Help:    "Accepted operator votes per transaction when its verdict was reached.",
+		Buckets: prometheus.LinearBuckets(0, 1, 26),
+	})
+
+	// VotesDiscarded counts votes that were not counted: invalid (unknown
+	// operator, bad signature, wrong transaction), duplicate, late (the
+	// transaction already has a verdict) or overflow.
+	VotesDiscarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dispatcher_votes_discarded_total",
+		Help: "Operator votes that were not counted, by reason.",
+	}, []string{"reason"})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dispatcher_rate_limit_rejections_total",
+		Help: "Requests rejected by the rate limiter, by tier.",
+	}, []string{"tier"})
+)
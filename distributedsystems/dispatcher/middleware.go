@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/metrics"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/auth"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/logger"
+)
+
+const (
+	scopeSubmitTransactions = "transactions:submit"
+	scopeReadTransactions   = "transactions:read"
+)
+
+type claimsKey struct{}
+
+// authMiddleware rejects requests without a valid bearer token and adds the
+// caller's subject to the request context so it's on every log line. It is a
+// no-op when no verifier is configured.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.verifier == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := s.verifier.Verify(r.Context(), token)
+		if err != nil {
+			slog.WarnContext(r.Context(), "rejected bearer token", "error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+		ctx = logger.AppendCtx(ctx, slog.String("subject", claims.Subject))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope only lets callers whose token grants scope through to next.
+func (s *Server) requireScope(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.verifier == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, ok := r.Context().Value(claimsKey{}).(*auth.Claims)
+		if !ok || !claims.HasScope(scope) {
+			slog.WarnContext(r.Context(), "caller is missing a required scope", "scope", scope)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			http.Error(w, "Insufficient scope", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiter limits each client, as identified by clientKey, to its tier's
+// budget and reports the budget in RateLimit-* headers. If the limiter's store
+// is unavailable the request is let through rather than failing the API.
+func (s *Server) rateLimiter(clientKey func(*http.Request) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, err := s.limiter.Allow(r.Context(), clientKey(r))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "rate limiter unavailable, allowing request", "error", err.Error())
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+			w.Header().Set("RateLimit-Policy", d.Limit.Policy())
+
+			if !d.Allowed {
+				metrics.RateLimitRejections.WithLabelValues(d.Tier).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies the caller for rate limiting: the token's subject when
+// authenticated, else the name of a known X-API-Key, else the client IP.
+// Unknown API keys are ignored so callers can't get a fresh bucket by making
+// one up.
+func (s *Server) clientKey(r *http.Request) string {
+	if claims, ok := r.Context().Value(claimsKey{}).(*auth.Claims); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		// Keys are looked up by hash so the lookup doesn't leak how much of
+		// a key was guessed right.
+		if name, ok := s.apiKeys[sha256.Sum256([]byte(key))]; ok {
+			return "key:" + name
+		}
+	}
+	return ipKey(r)
+}
+
+// ipKey identifies the caller by its IP address.
+func ipKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// seconds rounds up so clients never retry too early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+var tracer = otel.Tracer("github.com/rasha-hantash/golang/distributedsystems/dispatcher")
+
+// tracingMiddleware starts a server span for every request, continuing the
+// caller's trace when it sent a traceparent, and echoes the span's
+// traceparent on the response.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route := routeTemplate(r)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(w.Header()))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// metricsMiddleware counts requests and their latency by route and status.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeTemplate returns the matched route's path template, e.g.
+// /transaction/{id}, so labels don't grow with every transaction ID.
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unmatched"
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
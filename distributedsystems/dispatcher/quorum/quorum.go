@@ -0,0 +1,261 @@
+package quorum
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Outcome is the state of a transaction's vote collection after applying a Policy.
+type Outcome int
+
+const (
+	// Pending means more votes are needed before a verdict can be reached.
+	Pending Outcome = iota
+	// Approved means enough valid votes have been received.
+	Approved
+	// Rejected means the policy can no longer be satisfied, so there is no
+	// point in waiting for the remaining votes.
+	Rejected
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Approved:
+		return "approved"
+	case Rejected:
+		return "rejected"
+	default:
+		return "pending"
+	}
+}
+
+// Vote is a single operator's verdict on a transaction.
+type Vote struct {
+	OperatorID string
+	IsValid    bool
+}
+
+// Tally accumulates the votes received for a transaction.
+type Tally struct {
+	Votes   []Vote
+	Valid   int
+	Invalid int
+}
+
+// Add records a vote in the tally.
+func (t *Tally) Add(v Vote) {
+	t.Votes = append(t.Votes, v)
+	if v.IsValid {
+		t.Valid++
+	} else {
+		t.Invalid++
+	}
+}
+
+// Policy decides whether the votes collected so far are enough to approve or
+// reject a transaction.
+type Policy interface {
+	Decide(t Tally) Outcome
+	String() string
+}
+
+// Absolute approves once Required valid votes have arrived. When MaxInvalid is
+// greater than zero the transaction is rejected as soon as that many invalid
+// votes have arrived. When the number of Operators is known it is also
+// rejected once too few of them are left to reach Required.
+type Absolute struct {
+	Required   int
+	MaxInvalid int
+	Operators  int
+}
+
+func (p Absolute) Decide(t Tally) Outcome {
+	if t.Valid >= p.Required {
+		return Approved
+	}
+	if p.MaxInvalid > 0 && t.Invalid >= p.MaxInvalid {
+		return Rejected
+	}
+	if p.Operators > 0 && p.Operators-t.Invalid < p.Required {
+		return Rejected
+	}
+	return Pending
+}
+
+func (p Absolute) String() string {
+	if p.MaxInvalid > 0 {
+		return fmt.Sprintf("absolute:%d:%d", p.Required, p.MaxInvalid)
+	}
+	return fmt.Sprintf("absolute:%d", p.Required)
+}
+
+// Percentage approves once Fraction of the known Operators have voted valid,
+// and rejects as soon as too many have voted invalid for that to happen.
+type Percentage struct {
+	Fraction  float64
+	Operators int
+}
+
+func (p Percentage) required() int {
+	return int(math.Ceil(p.Fraction * float64(p.Operators)))
+}
+
+func (p Percentage) Decide(t Tally) Outcome {
+	required := p.required()
+	if t.Valid >= required {
+		return Approved
+	}
+	if p.Operators-t.Invalid < required {
+		return Rejected
+	}
+	return Pending
+}
+
+func (p Percentage) String() string {
+	return fmt.Sprintf("percentage:%g", p.Fraction)
+}
+
+// Weighted gives each operator a weight and approves once the weight of the
+// valid votes reaches Threshold. Operators missing from Weights count as
+// DefaultWeight. When Weights lists every operator, the transaction is
+// rejected as soon as the remaining weight can no longer reach Threshold.
+type Weighted struct {
+	Weights       map[string]float64
+	DefaultWeight float64
+	Threshold     float64
+}
+
+func (p Weighted) weight(operatorID string) float64 {
+	if w, ok := p.Weights[operatorID]; ok {
+		return w
+	}
+	return p.DefaultWeight
+}
+
+func (p Weighted) Decide(t Tally) Outcome {
+	var valid, invalid, total float64
+	for _, v := range t.Votes {
+		if v.IsValid {
+			valid += p.weight(v.OperatorID)
+		} else {
+			invalid += p.weight(v.OperatorID)
+		}
+	}
+	if valid >= p.Threshold {
+		return Approved
+	}
+	for _, w := range p.Weights {
+		total += w
+	}
+	if p.DefaultWeight == 0 && total-invalid < p.Threshold {
+		return Rejected
+	}
+	return Pending
+}
+
+func (p Weighted) String() string {
+	return fmt.Sprintf("weighted:%g", p.Threshold)
+}
+
+// Unanimous approves once Required valid votes have arrived without a single
+// invalid vote, and rejects on the first invalid vote.
+type Unanimous struct {
+	Required int
+}
+
+func (p Unanimous) Decide(t Tally) Outcome {
+	if t.Invalid > 0 {
+		return Rejected
+	}
+	if t.Valid >= p.Required {
+		return Approved
+	}
+	return Pending
+}
+
+func (p Unanimous) String() string {
+	return fmt.Sprintf("unanimous:%d", p.Required)
+}
+
+// Default matches the dispatcher's original behaviour of approving after five
+// valid votes, rejecting as soon as so many of the operators voted invalid
+// that five valid votes can no longer arrive. operators is how many operators
+// there are, or 0 if unknown, in which case only the timeout rejects.
+func Default(operators int) Policy {
+	return Absolute{Required: 5, Operators: operators}
+}
+
+// Parse builds a Policy from a spec string such as "absolute:5",
+// "absolute:5:2", "percentage:0.66", "weighted:3" or "unanimous:5".
+// operators is how many operators there are, or 0 if unknown; the percentage
+// policy needs it. weights is only used by the weighted policy.
+func Parse(spec string, operators int, weights map[string]float64) (Policy, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	args := parts[1:]
+
+	switch parts[0] {
+	case "absolute":
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("absolute policy expects absolute:<required>[:<max_invalid>], got %q", spec)
+		}
+		required, err := parsePositiveInt(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid required votes in %q: %w", spec, err)
+		}
+		p := Absolute{Required: required, Operators: operators}
+		if len(args) == 2 {
+			if p.MaxInvalid, err = parsePositiveInt(args[1]); err != nil {
+				return nil, fmt.Errorf("invalid max invalid votes in %q: %w", spec, err)
+			}
+		}
+		return p, nil
+	case "percentage":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("percentage policy expects percentage:<fraction>, got %q", spec)
+		}
+		fraction, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || fraction <= 0 || fraction > 1 {
+			return nil, fmt.Errorf("invalid fraction in %q: must be in (0, 1]", spec)
+		}
+		if operators <= 0 {
+			return nil, fmt.Errorf("percentage policy %q requires the operators to be known", spec)
+		}
+		return Percentage{Fraction: fraction, Operators: operators}, nil
+	case "weighted":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("weighted policy expects weighted:<threshold>, got %q", spec)
+		}
+		threshold, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid threshold in %q: must be positive", spec)
+		}
+		if len(weights) == 0 {
+			return nil, fmt.Errorf("weighted policy %q requires operator weights", spec)
+		}
+		return Weighted{Weights: weights, Threshold: threshold}, nil
+	case "unanimous":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("unanimous policy expects unanimous:<required>, got %q", spec)
+		}
+		required, err := parsePositiveInt(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid required votes in %q: %w", spec, err)
+		}
+		return Unanimous{Required: required}, nil
+	default:
+		return nil, fmt.Errorf("unknown quorum policy %q", spec)
+	}
+}
+
+func parsePositiveInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive, got %d", n)
+	}
+	return n, nil
+}
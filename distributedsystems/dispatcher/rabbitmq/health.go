@@ -0,0 +1,134 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/health"
+)
+
+// operatorActivityWindow is how recently a vote must have arrived for operator
+// activity to pass.
+const operatorActivityWindow = 5 * time.Minute
+
+// notRabbitMQ is reported by the RabbitMQ checks for a service created with
+// NewService on another broker.
+var notRabbitMQ = health.Pass("not using rabbitmq")
+
+// CheckConnection checks that the AMQP connection and channel are open.
+func (rmq *RabbitMQService) CheckConnection(ctx context.Context) health.Check {
+	if rmq.conn == nil {
+		return notRabbitMQ
+	}
+	conn, err := rmq.conn.Connection()
+	if err != nil || conn.IsClosed() {
+		return notConnected(rmq.conn.LastError())
+	}
+	ch, err := rmq.conn.Channel()
+	if err != nil || ch.IsClosed() {
+		return notConnected(rmq.conn.LastError())
+	}
+	return health.Pass("")
+}
+
+func notConnected(lastErr error) health.Check {
+	if lastErr != nil {
+		return health.Fail(fmt.Sprintf("reconnecting: %v", lastErr))
+	}
+	return health.Fail("reconnecting")
+}
+
+// exchangeCheckInterval is how long the result of the exchange check is
+// reused, so probes don't open a channel on the broker each time.
+const exchangeCheckInterval = 10 * time.Second
+
+type cachedCheck struct {
+	check health.Check
+	at    time.Time
+}
+
+// CheckExchange checks that the transaction_requests exchange exists. The
+// result is reused for exchangeCheckInterval and concurrent probes share one
+// check; a probe whose ctx is done stops waiting for it.
+func (rmq *RabbitMQService) CheckExchange(ctx context.Context) health.Check {
+	if rmq.conn == nil {
+		return notRabbitMQ
+	}
+	if cached := rmq.exchangeCheck.Load(); cached != nil && time.Since(cached.at) < exchangeCheckInterval {
+		return cached.check
+	}
+
+	result := rmq.exchangeChecks.DoChan(exchangeName, func() (any, error) {
+		check := rmq.declareExchangePassive()
+		rmq.exchangeCheck.Store(&cachedCheck{check: check, at: time.Now()})
+		return check, nil
+	})
+	select {
+	case r := <-result:
+		return r.Val.(health.Check)
+	case <-ctx.Done():
+		return health.Fail(fmt.Sprintf("exchange %s: %v", exchangeName, ctx.Err()))
+	}
+}
+
+// declareExchangePassive checks the exchange on a throwaway channel because a
+// failed passive declare closes the channel it runs on.
+func (rmq *RabbitMQService) declareExchangePassive() health.Check {
+	conn, err := rmq.conn.Connection()
+	if err != nil {
+		return health.Fail(err.Error())
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return health.Fail(fmt.Sprintf("failed to open a channel: %v", err))
+	}
+	defer ch.Close()
+
+	if err := ch.ExchangeDeclarePassive(exchangeName, "fanout", true, false, false, false, nil); err != nil {
+		return health.Fail(fmt.Sprintf("exchange %s: %v", exchangeName, err))
+	}
+	return health.Pass("")
+}
+
+// CheckToken checks that the token used to log in to RabbitMQ hasn't
+// expired, which means refreshing it has been failing.
+func (rmq *RabbitMQService) CheckToken(ctx context.Context) health.Check {
+	if rmq.tokens == nil {
+		return notRabbitMQ
+	}
+	expiresAt := rmq.tokens.ExpiresAt()
+	switch {
+	case expiresAt.IsZero():
+		return health.Fail("no token")
+	case time.Now().After(expiresAt):
+		return health.Fail(fmt.Sprintf("token expired at %s", expiresAt.UTC().Format(time.RFC3339)))
+	}
+	return health.Pass(fmt.Sprintf("expires at %s", expiresAt.UTC().Format(time.RFC3339)))
+}
+
+// CheckOperatorActivity reports whether operators have voted recently. It
+// only warns: a quiet period without transactions has no votes either.
+func (rmq *RabbitMQService) CheckOperatorActivity(ctx context.Context) health.Check {
+	lastVote := loadTime(&rmq.lastVoteAt)
+	lastBroadcast := loadTime(&rmq.lastBroadcastAt)
+
+	if !lastBroadcast.IsZero() && lastBroadcast.Add(rmq.responseTimeout).Before(time.Now()) && lastBroadcast.After(lastVote) {
+		return health.Warn(fmt.Sprintf("no operator voted on the last broadcast at %s", lastBroadcast.UTC().Format(time.RFC3339)))
+	}
+	if lastVote.IsZero() {
+		return health.Warn("no votes received yet")
+	}
+	if since := time.Since(lastVote); since > operatorActivityWindow {
+		return health.Warn(fmt.Sprintf("last vote received %s ago", since.Round(time.Second)))
+	}
+	return health.Pass(fmt.Sprintf("last vote received at %s", lastVote.UTC().Format(time.RFC3339)))
+}
+
+func loadTime(v interface{ Load() int64 }) time.Time {
+	nanos := v.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,180 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/ledger"
+)
+
+const errKeyReused = "Idempotency-Key was already used for a different transaction"
+
+// inflightTransaction is a transaction that is still collecting votes. Retries
+// with the same idempotency key wait on done instead of broadcasting again.
+type inflightTransaction struct {
+	txnID   string
+	request TransactionRequest
+	done    chan struct{}
+}
+
+// idempotencyKey returns the request's Idempotency-Key header, falling back to
+// the transaction hash.
+func idempotencyKey(r *http.Request, txnRequest TransactionRequest) string {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	return txnRequest.TxnHash
+}
+
+// claim registers txnID as the in-flight transaction for key. If another
+// request already holds the key, its in-flight transaction is returned
+// instead and ok is false.
+func (rmq *RabbitMQService) claim(key, txnID string, txnRequest TransactionRequest) (txn *inflightTransaction, ok bool) {
+	rmq.inflightMu.Lock()
+	defer rmq.inflightMu.Unlock()
+
+	if existing, found := rmq.inflight[key]; found {
+		return existing, false
+	}
+	txn = &inflightTransaction{
+		txnID:   txnID,
+		request: txnRequest,
+		done:    make(chan struct{}),
+	}
+	rmq.inflight[key] = txn
+	return txn, true
+}
+
+// inflightFor returns the in-flight transaction holding key, or nil.
+func (rmq *RabbitMQService) inflightFor(key string) *inflightTransaction {
+	rmq.inflightMu.Lock()
+	defer rmq.inflightMu.Unlock()
+	return rmq.inflight[key]
+}
+
+// release removes key from the in-flight transactions and wakes up any
+// retries waiting on it. It must be called once the verdict is in the ledger.
+func (rmq *RabbitMQService) release(key string) {
+	rmq.inflightMu.Lock()
+	txn, ok := rmq.inflight[key]
+	delete(rmq.inflight, key)
+	rmq.inflightMu.Unlock()
+
+	if ok {
+		close(txn.done)
+	}
+}
+
+// replay answers a retried submission with the original transaction's
+// result. Sync retries of an in-flight transaction wait for its verdict.
+func (rmq *RabbitMQService) replay(w http.ResponseWriter, r *http.Request, txnID string, done <-chan struct{}) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "replaying idempotent submission", "transaction_id", txnID)
+	w.Header().Set("Idempotent-Replayed", "true")
+
+	if done != nil && !isAsync(r) {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	txn, err := rmq.ledger.GetTransaction(ctx, txnID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error looking up transaction", "transaction_id", txnID, "error", err.Error())
+		http.Error(w, "Failed to look up transaction", http.StatusInternalServerError)
+		return
+	}
+
+	if txn.Status != ledger.StatusCompleted || isAsync(r) {
+		writeAccepted(w, txn.ID, txn.Status)
+		return
+	}
+	sendJSONResponse(w, verdictFromLedger(txn))
+}
+
+// replayInflight answers a retry of a transaction that is still collecting
+// votes.
+func (rmq *RabbitMQService) replayInflight(w http.ResponseWriter, r *http.Request, inflight *inflightTransaction, txnRequest TransactionRequest) {
+	if !sameTransaction(inflight.request, txnRequest) {
+		http.Error(w, errKeyReused, http.StatusUnprocessableEntity)
+		return
+	}
+	rmq.replay(w, r, inflight.txnID, inflight.done)
+}
+
+// sameTransaction reports whether a retry carries the same transaction as
+// the one originally submitted under its idempotency key.
+func sameTransaction(a TransactionRequest, b TransactionRequest) bool {
+	return a.TxnHash == b.TxnHash && a.From == b.From && a.To == b.To && a.Value == b.Value
+}
+
+func requestFromLedger(txn *ledger.Transaction) TransactionRequest {
+	return TransactionRequest{
+		TransactionID: txn.ID,
+		TxnHash:       txn.TxnHash,
+		From:          txn.From,
+		To:            txn.To,
+		Value:         txn.Value,
+	}
+}
+
+func verdictFromLedger(txn *ledger.Transaction) *Verdict {
+	verdict := &Verdict{
+		TransactionID: txn.ID,
+		IsCompliant:   txn.IsCompliant != nil && *txn.IsCompliant,
+		ValidVotes:    txn.ValidVotes,
+		InvalidVotes:  txn.InvalidVotes,
+		MissingVotes:  txn.MissingVotes,
+		Votes:         make([]OperatorVote, 0, len(txn.Votes)),
+		QuorumPolicy:  txn.QuorumPolicy,
+		DecidedBy:     txn.DecidedBy,
+		ElapsedMS:     txn.ElapsedMS,
+	}
+	for _, v := range txn.Votes {
+		verdict.Votes = append(verdict.Votes, OperatorVote{
+			OperatorID:  v.OperatorID,
+			IsValid:     v.IsValid,
+			Reason:      v.Reason,
+			ListVersion: v.ListVersion,
+			ReceivedAt:  v.ReceivedAt,
+		})
+	}
+	return verdict
+}
+
+func writeAccepted(w http.ResponseWriter, txnID, status string) {
+	w.Header().Set("Location", "/transaction/"+txnID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"transaction_id": txnID,
+		"status":         status,
+		"status_url":     "/transaction/" + txnID,
+	})
+}
+
+// replayExisting answers with the transaction already recorded under key, if
+// there is one, and reports whether it did.
+func (rmq *RabbitMQService) replayExisting(w http.ResponseWriter, r *http.Request, key string, txnRequest TransactionRequest) bool {
+	ctx := r.Context()
+	existing, err := rmq.ledger.FindByIdempotencyKey(ctx, key)
+	if errors.Is(err, ledger.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error looking up idempotency key", "error", err.Error())
+		http.Error(w, "Failed to look up transaction", http.StatusInternalServerError)
+		return true
+	}
+
+	if !sameTransaction(requestFromLedger(existing), txnRequest) {
+		http.Error(w, errKeyReused, http.StatusUnprocessableEntity)
+		return true
+	}
+	rmq.replay(w, r, existing.ID, nil)
+	return true
+}
@@ -0,0 +1,87 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/ledger"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/quorum"
+)
+
+// GetTransaction looks up a transaction and its verdict in the ledger.
+func (rmq *RabbitMQService) GetTransaction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	txn, err := rmq.ledger.GetTransaction(ctx, id)
+	if errors.Is(err, ledger.ErrNotFound) {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error looking up transaction", "transaction_id", id, "error", err.Error())
+		http.Error(w, "Failed to look up transaction", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, txn)
+}
+
+func (rmq *RabbitMQService) recordTransaction(ctx context.Context, txnRequest TransactionRequest, idempotencyKey string, policy quorum.Policy, start time.Time) error {
+	return rmq.ledger.CreateTransaction(ctx, ledger.Transaction{
+		ID:             txnRequest.TransactionID,
+		IdempotencyKey: idempotencyKey,
+		TxnHash:        txnRequest.TxnHash,
+		From:           txnRequest.From,
+		To:             txnRequest.To,
+		Value:          txnRequest.Value,
+		QuorumPolicy:   policy.String(),
+		CreatedAt:      start.UTC(),
+	})
+}
+
+// recordVote and completeTransaction only log failures: the verdict has
+// already been reached and the caller should still receive it. They run
+// without the request's deadline so a timed out transaction is still recorded.
+func (rmq *RabbitMQService) recordVote(ctx context.Context, transactionID string, vote OperatorVote, signature []byte) {
+	err := rmq.ledger.RecordVote(context.WithoutCancel(ctx), transactionID, ledger.Vote{
+		OperatorID:  vote.OperatorID,
+		IsValid:     vote.IsValid,
+		Reason:      vote.Reason,
+		ListVersion: vote.ListVersion,
+		Signature:   signature,
+		ReceivedAt:  vote.ReceivedAt,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording vote", "transaction_id", transactionID, "operator_id", vote.OperatorID, "error", err.Error())
+	}
+}
+
+func (rmq *RabbitMQService) releaseIdempotencyKey(ctx context.Context, transactionID string) {
+	if err := rmq.ledger.ReleaseIdempotencyKey(context.WithoutCancel(ctx), transactionID); err != nil {
+		slog.ErrorContext(ctx, "error releasing idempotency key", "transaction_id", transactionID, "error", err.Error())
+	}
+}
+
+func (rmq *RabbitMQService) completeTransaction(ctx context.Context, verdict *Verdict) {
+	completedAt := time.Now().UTC()
+	isCompliant := verdict.IsCompliant
+	err := rmq.ledger.CompleteTransaction(context.WithoutCancel(ctx), ledger.Transaction{
+		ID:           verdict.TransactionID,
+		IsCompliant:  &isCompliant,
+		DecidedBy:    verdict.DecidedBy,
+		ValidVotes:   verdict.ValidVotes,
+		InvalidVotes: verdict.InvalidVotes,
+		MissingVotes: verdict.MissingVotes,
+		ElapsedMS:    verdict.ElapsedMS,
+		CompletedAt:  &completedAt,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording verdict", "transaction_id", verdict.TransactionID, "error", err.Error())
+	}
+}
@@ -2,20 +2,39 @@ package rabbitmq
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"time"
 
 	"github.com/segmentio/ksuid"
 
-	"github.com/rasha-hantash/golang/distributedsystems/libs/auth"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/ledger"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/metrics"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/quorum"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/webhook"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/auth"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/broker"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/correlation"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/rmqconn"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/tracing"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/vote"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
+var tracer = otel.Tracer("github.com/rasha-hantash/golang/distributedsystems/dispatcher/rabbitmq")
 
 type TransactionRequest struct {
 	TransactionID string `json:"transaction_id"`
@@ -28,40 +47,264 @@ type TransactionRequest struct {
 type TransactionResponse struct {
 	TransactionID string `json:"transaction_id"`
 	IsValid       bool   `json:"is_valid"`
+	OperatorID    string `json:"operator_id"`
+	Reason        string `json:"reason"`
+	ListVersion   string `json:"list_version"`
+	Signature     []byte `json:"signature"`
 }
 
-type RabbitMQService struct {
-	rabbitMQConn *amqp.Connection
-	rabbitMQChan   *amqp.Channel
+// Verdict is the dispatcher's decision on a transaction along with the votes
+// that led to it.
+type Verdict struct {
+	TransactionID string         `json:"transaction_id"`
+	IsCompliant   bool           `json:"is_compliant"`
+	ValidVotes    int            `json:"valid_votes"`
+	InvalidVotes  int            `json:"invalid_votes"`
+	MissingVotes  int            `json:"missing_votes"`
+	Votes         []OperatorVote `json:"votes"`
+	QuorumPolicy  string         `json:"quorum_policy"`
+	DecidedBy     string         `json:"decided_by"`
+	ElapsedMS     int64          `json:"elapsed_ms"`
+}
 
+type OperatorVote struct {
+	OperatorID  string    `json:"operator_id"`
+	IsValid     bool      `json:"is_valid"`
+	Reason      string    `json:"reason,omitempty"`
+	ListVersion string    `json:"list_version,omitempty"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+const (
+	DecidedByQuorum  = "quorum"
+	DecidedByTimeout = "timeout"
+	DecidedByError   = "error"
+	// DecidedByUnavailable means no operator was subscribed, or the broker
+	// refused the transaction, so it was never broadcast.
+	DecidedByUnavailable = "unavailable"
+)
+
+// DefaultResponseTimeout is how long the dispatcher waits for operators to
+// reach a verdict before giving up.
+const DefaultResponseTimeout = 5 * time.Second
+
+// exchangeName is the fanout exchange transactions are broadcast on.
+const exchangeName = "transaction_requests"
+
+// noOperatorsRetryAfter is the Retry-After sent with a 503 when no operator is
+// available, roughly how long operators take to reconnect.
+const noOperatorsRetryAfter = "5"
+
+type RabbitMQService struct {
+	broker broker.Broker
+	// conn and tokens are only set when connected to RabbitMQ, see
+	// NewConnection.
+	conn            *rmqconn.Supervisor
+	tokens          *auth.TokenSource
+	quorumPolicy    quorum.Policy
+	operatorWeights map[string]float64
+	operatorKeys    map[string]ed25519.PublicKey
+	ledger          ledger.Ledger
+	webhooks        *webhook.Client
+	// maxTransactionValue is the largest value accepted, 0 means no limit
+	maxTransactionValue int64
+	// quorumOverrides holds the String of every policy a request may ask
+	// for.
+	quorumOverrides map[string]bool
+	responseTimeout time.Duration
+
+	// background tracks async broadcasts and callbacks so shutdown can wait
+	// for them.
+	background sync.WaitGroup
+
+	// lastBroadcastAt and lastVoteAt are unix nanos, read by the readiness
+	// check for operator activity.
+	lastBroadcastAt atomic.Int64
+	lastVoteAt      atomic.Int64
+	// exchangeCheck is the last result of CheckExchange and exchangeChecks
+	// shares a running check between probes.
+	exchangeCheck  atomic.Pointer[cachedCheck]
+	exchangeChecks singleflight.Group
+
+	inflightMu sync.Mutex
+	inflight   map[string]*inflightTransaction
+
+	// replyQueue is where operators send their votes. pending maps the
+	// transactions still collecting votes to their collector, see
+	// awaitResponses.
+	replyQueue string
+	pendingMu  sync.Mutex
+	pending    map[string]chan broker.Delivery
 }
 
 type RabbitMQConfig struct {
-	Host     string
-	Port     string
-	Auth0Config auth.Auth0Config
+	Host string
+	Port string
+	// Credentials are exchanged for the OAuth2 token used to log in to
+	// RabbitMQ.
+	Credentials auth.ClientCredentialsConfig
+	// QuorumPolicy is applied to every transaction unless the request asks
+	// for a different one. Defaults to quorum.Default for the OperatorKeys.
+	QuorumPolicy quorum.Policy
+	// QuorumOverrides are the other policies a request may ask for. Requests
+	// can't pick their own policy when it is empty, or a caller could ask for
+	// approval by a single operator.
+	QuorumOverrides []quorum.Policy
+	// OperatorWeights is used when a request asks for a weighted policy.
+	OperatorWeights map[string]float64
+	// OperatorKeys holds the public key of every operator allowed to vote.
+	// It is required unless UnsignedVotes is set.
+	OperatorKeys map[string]ed25519.PublicKey
+	// UnsignedVotes counts votes without checking their signatures when
+	// OperatorKeys is empty; they are still de-duplicated per operator. It
+	// must only be used in development.
+	UnsignedVotes bool
+	// MaxTransactionValue rejects transactions above this value when set.
+	MaxTransactionValue int64
+	// Ledger records every transaction and verdict. Defaults to an in-memory
+	// ledger.
+	Ledger ledger.Ledger
+	// PublishChannels caps how many broadcasts are published at once; more
+	// wait for a channel to free up. Defaults to rmqconn.DefaultPoolSize.
+	PublishChannels int
+	// Webhooks delivers callbacks. Defaults to a client that refuses private
+	// addresses.
+	Webhooks *webhook.Client
+	// ResponseTimeout is how long to wait for a verdict. Defaults to
+	// DefaultResponseTimeout.
+	ResponseTimeout time.Duration
 }
 
-func NewConnection(rabbitmqCfg RabbitMQConfig) (*RabbitMQService, error) {
-	auth0Token, err := auth.GetAuth0Token(rabbitmqCfg.Auth0Config)
-	if err != nil {
-		log.Fatalf("Error getting token: %v", err)
+// NewConnection connects to RabbitMQ, declares the transaction_requests
+// exchange and starts consuming votes from the dispatcher's reply queue.
+// Connection errors are *rmqconn.ConnectError and match rmqconn.ErrAuth,
+// ErrDial or ErrTopology.
+func NewConnection(ctx context.Context, rabbitmqCfg RabbitMQConfig) (*RabbitMQService, error) {
+	// Check the config before connecting so it isn't reported as a topology
+	// error below.
+	if err := requireOperatorKeys(rabbitmqCfg); err != nil {
+		return nil, err
 	}
 
 	rabbitmqURL := fmt.Sprintf("amqp://%s:5672", rabbitmqCfg.Host)
-	// Create a custom dialer that includes the OAuth2 token
-	conn, err := amqp.DialConfig(rabbitmqURL, amqp.Config{
-		Heartbeat: 10 * time.Second,
-		Locale:    "en_US",
-		SASL:      []amqp.Authentication{&amqp.PlainAuth{Username: "", Password: auth0Token}},
+	tokens := auth.NewClientCredentialsTokenSource(rabbitmqCfg.Credentials)
+
+	conn, err := rmqconn.New(ctx, rmqconn.Config{
+		// The token source hands out a cached token that is refreshed before
+		// it expires, so reconnects never dial with an expired one.
+		Dial: func() (*amqp.Connection, error) {
+			accessToken, err := tokens.Token()
+			if err != nil {
+				return nil, rmqconn.AuthFailed(fmt.Errorf("error getting token: %w", err))
+			}
+			// Create a custom dialer that includes the OAuth2 token
+			return amqp.DialConfig(rabbitmqURL, amqp.Config{
+				Heartbeat: 10 * time.Second,
+				Locale:    "en_US",
+				SASL:      []amqp.Authentication{&amqp.PlainAuth{Username: "", Password: accessToken}},
+			})
+		},
+		Setup: declareTopology,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Hand refreshed tokens to the broker so it doesn't close the connection
+	// when the token it was opened with expires.
+	go tokens.AutoRefresh(ctx, func(token string) {
+		if err := conn.UpdateSecret(token, "token refresh"); err != nil {
+			slog.WarnContext(ctx, "failed to update rabbitmq secret", "error", err.Error())
+		}
 	})
-	failOnError(err, "Failed to open a connection")
-	ch, err := conn.Channel()
-	failOnError(err, "Failed to open a channel")
-	
 
-	err = ch.ExchangeDeclare(
-		"transaction_requests",
+	rmq, err := NewService(ctx, broker.NewAMQP(conn, rabbitmqCfg.PublishChannels), rabbitmqCfg)
+	if err != nil {
+		conn.Close()
+		return nil, &rmqconn.ConnectError{Kind: rmqconn.ErrTopology, Err: err}
+	}
+	rmq.conn = conn
+	rmq.tokens = tokens
+	return rmq, nil
+}
+
+// NewService returns a service that broadcasts over b, e.g. a broker.Memory
+// in tests, and consumes votes until ctx is done. Host, Port and Credentials
+// are not used.
+func NewService(ctx context.Context, b broker.Broker, rabbitmqCfg RabbitMQConfig) (*RabbitMQService, error) {
+	if err := requireOperatorKeys(rabbitmqCfg); err != nil {
+		return nil, err
+	}
+
+	policy := rabbitmqCfg.QuorumPolicy
+	if policy == nil {
+		policy = quorum.Default(len(rabbitmqCfg.OperatorKeys))
+	}
+
+	txnLedger := rabbitmqCfg.Ledger
+	if txnLedger == nil {
+		txnLedger = ledger.NewMemoryLedger()
+	}
+
+	webhooks := rabbitmqCfg.Webhooks
+	if webhooks == nil {
+		webhooks = webhook.NewClient(webhook.Config{})
+	}
+
+	responseTimeout := rabbitmqCfg.ResponseTimeout
+	if responseTimeout <= 0 {
+		responseTimeout = DefaultResponseTimeout
+	}
+
+	quorumOverrides := map[string]bool{policy.String(): true}
+	for _, override := range rabbitmqCfg.QuorumOverrides {
+		quorumOverrides[override.String()] = true
+	}
+
+	rmq := &RabbitMQService{
+		broker:              b,
+		quorumPolicy:        policy,
+		quorumOverrides:     quorumOverrides,
+		responseTimeout:     responseTimeout,
+		operatorWeights:     rabbitmqCfg.OperatorWeights,
+		operatorKeys:        rabbitmqCfg.OperatorKeys,
+		ledger:              txnLedger,
+		webhooks:            webhooks,
+		maxTransactionValue: rabbitmqCfg.MaxTransactionValue,
+		inflight:            make(map[string]*inflightTransaction),
+		replyQueue:          replyQueuePrefix + ksuid.New().String(),
+		pending:             make(map[string]chan broker.Delivery),
+	}
+
+	// Consume replies before accepting any transaction so no vote is sent to
+	// a queue that doesn't exist yet.
+	responses, err := b.ConsumeReplies(ctx, rmq.replyQueue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume replies: %w", err)
+	}
+	go rmq.consumeReplies(ctx, responses)
+	return rmq, nil
+}
+
+func requireOperatorKeys(rabbitmqCfg RabbitMQConfig) error {
+	if len(rabbitmqCfg.OperatorKeys) == 0 && !rabbitmqCfg.UnsignedVotes {
+		return fmt.Errorf("operator keys are required to verify votes")
+	}
+	return nil
+}
+
+// transactionSubmission is the body of POST /transaction. CallbackURL is not
+// broadcast to operators.
+type transactionSubmission struct {
+	TransactionRequest
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// declareTopology declares the exchange transactions are fanned out on. It
+// runs again after every reconnect.
+func declareTopology(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		exchangeName,
 		"fanout",
 		true,
 		false,
@@ -69,138 +312,423 @@ func NewConnection(rabbitmqCfg RabbitMQConfig) (*RabbitMQService, error) {
 		false,
 		nil,
 	)
-	failOnError(err, "Failed to declare an exchange")
-
-	return &RabbitMQService{
-		rabbitMQConn: conn,
-		rabbitMQChan:   ch,
-	}, nil
-
 }
 
+// BroadcastTransaction fans a transaction out to the operators and answers
+// with the verdict. When the request asks for async processing, either with
+// ?async=true or a "Prefer: respond-async" header, it answers 202 straight
+// away and the verdict is available from GET /transaction/{id} and the
+// optional callback_url.
 func (rmq *RabbitMQService) BroadcastTransaction(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	ctx := r.Context()
 	slog.InfoContext(ctx, "broadcasting transaction")
-	defer cancel()
 
-	var txnRequest TransactionRequest
-	if err := json.NewDecoder(r.Body).Decode(&txnRequest); err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+	submission, err := decodeSubmission(w, r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	fieldErrs := append(rmq.validate(submission), validateIdempotencyKey(r.Header)...)
+	policy := rmq.quorumPolicy
+	if spec := r.URL.Query().Get("quorum"); spec != "" {
+		p, err := quorum.Parse(spec, len(rmq.operatorKeys), rmq.operatorWeights)
+		switch {
+		case err != nil:
+			fieldErrs = append(fieldErrs, FieldError{Field: "quorum", Message: err.Error()})
+		case !rmq.quorumOverrides[p.String()]:
+			fieldErrs = append(fieldErrs, FieldError{Field: "quorum", Message: fmt.Sprintf("policy %s is not allowed", p)})
+		}
+		policy = p
+	}
+	if len(fieldErrs) > 0 {
+		writeValidationError(w, http.StatusBadRequest, validationErrorResponse{
+			Error:  "invalid transaction request",
+			Fields: fieldErrs,
+		})
+		return
+	}
+	txnRequest := submission.TransactionRequest
+
+	// Retries of a transaction that was already submitted get the original
+	// result instead of a second broadcast. In-flight transactions are checked
+	// first: their ledger row is still pending, and sync retries wait for the
+	// verdict instead.
+	key := idempotencyKey(r, txnRequest)
+	if inflight := rmq.inflightFor(key); inflight != nil {
+		rmq.replayInflight(w, r, inflight, txnRequest)
+		return
+	}
+	if rmq.replayExisting(w, r, key, txnRequest) {
 		return
 	}
 
+	start := time.Now()
 	txnID := fmt.Sprintf("txn_%s", ksuid.New().String())
 	txnRequest.TransactionID = txnID
 
-	responseQueue, err := rmq.createResponseQueue(txnID)
-	if err != nil {
-		slog.ErrorContext(ctx, "error creating response queue","error", err.Error())
-		http.Error(w, "Failed to create response queue", http.StatusInternalServerError)
+	inflight, claimed := rmq.claim(key, txnID, txnRequest)
+	if !claimed {
+		rmq.replayInflight(w, r, inflight, txnRequest)
 		return
 	}
 
-	if err := rmq.publishTransaction(ctx, txnRequest); err != nil {
-		http.Error(w, "Failed to publish message", http.StatusInternalServerError)
+	if err := rmq.recordTransaction(ctx, txnRequest, key, policy, start); err != nil {
+		rmq.release(key)
+		// Another dispatcher may have recorded the same key in the meantime.
+		if errors.Is(err, ledger.ErrDuplicate) && rmq.replayExisting(w, r, key, txnRequest) {
+			return
+		}
+		slog.ErrorContext(ctx, "error recording transaction", "transaction_id", txnID, "error", err.Error())
+		http.Error(w, "Failed to record transaction", http.StatusInternalServerError)
+		return
+	}
+
+	if isAsync(r) {
+		// The broadcast outlives the HTTP request, so detach it from the
+		// request's cancellation while keeping its logging attributes.
+		rmq.background.Add(1)
+		go func() {
+			defer rmq.background.Done()
+			ctx := context.WithoutCancel(ctx)
+			// Only the broadcast is bounded by the response window; the
+			// callback and its retries run after it, once retries waiting
+			// on the key have been woken up.
+			broadcastCtx, cancel := context.WithTimeout(ctx, rmq.responseTimeout)
+			verdict, err := rmq.broadcast(broadcastCtx, txnRequest, policy, start)
+			cancel()
+			rmq.release(key)
+			if err != nil {
+				slog.ErrorContext(ctx, "error broadcasting transaction", "transaction_id", txnID, "error", err.Error())
+			}
+			rmq.notify(ctx, submission.CallbackURL, verdict)
+		}()
+
+		writeAccepted(w, txnID, ledger.StatusPending)
 		return
 	}
 
-	isCompliant, err := rmq.collectResponses(ctx, responseQueue.Name, txnID)
+	// A client that disconnects or gives up early must not leave a timeout
+	// verdict behind for its retries to replay, so the broadcast only ends
+	// with the response window.
+	defer rmq.release(key)
+	broadcastCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rmq.responseTimeout)
+	defer cancel()
+	verdict, err := rmq.broadcast(broadcastCtx, txnRequest, policy, start)
+	if noOperators(err) {
+		slog.WarnContext(ctx, "no operators available", "transaction_id", txnID, "error", err.Error())
+		w.Header().Set("Retry-After", noOperatorsRetryAfter)
+		http.Error(w, "No operators available", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "error broadcasting transaction", "transaction_id", txnID, "error", err.Error())
+		http.Error(w, "Failed to broadcast transaction", http.StatusInternalServerError)
 		return
 	}
 
-	sendJSONResponse(w, map[string]bool{"is_compliant": isCompliant})
+	rmq.background.Add(1)
+	go func() {
+		defer rmq.background.Done()
+		rmq.notify(context.WithoutCancel(ctx), submission.CallbackURL, verdict)
+	}()
+	sendJSONResponse(w, verdict)
 }
 
-func (rmq *RabbitMQService) createResponseQueue(txnID string) (amqp.Queue, error) {
-	return rmq.rabbitMQChan.QueueDeclare(
-		txnID, // name
-		false, // durable
-		false, // auto delete
-		true,  // exclusive -> important to ensure that no one else can recieve responses from this transaction responses queue 
-		false,
-		nil,
-	)
+func isAsync(r *http.Request) bool {
+	if r.URL.Query().Get("async") == "true" {
+		return true
+	}
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.TrimSpace(pref) == "respond-async" {
+			return true
+		}
+	}
+	return false
 }
 
-func  (rmq *RabbitMQService) publishTransaction(ctx context.Context, txnRequest TransactionRequest) error {
-	txnRequestByte, err := json.Marshal(txnRequest)
+// broadcast publishes a transaction, waits for a verdict and records it in the
+// ledger. A failed broadcast is recorded as a non-compliant verdict decided by
+// error, so the transaction does not stay pending, or by unavailable when no
+// operator could be reached. Either way it gives up its idempotency key so the
+// client can retry.
+func (rmq *RabbitMQService) broadcast(ctx context.Context, txnRequest TransactionRequest, policy quorum.Policy, start time.Time) (*Verdict, error) {
+	txnID := txnRequest.TransactionID
+
+	ctx, span := tracer.Start(ctx, "broadcast transaction", trace.WithAttributes(
+		attribute.String("transaction.id", txnID),
+		attribute.String("quorum.policy", policy.String()),
+	))
+	defer span.End()
+
+	verdict, err := rmq.publishAndCollect(ctx, txnRequest, policy, start)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transaction request: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		verdict = &Verdict{
+			TransactionID: txnID,
+			Votes:         []OperatorVote{},
+			QuorumPolicy:  policy.String(),
+			DecidedBy:     DecidedByError,
+			ElapsedMS:     time.Since(start).Milliseconds(),
+		}
+		if noOperators(err) {
+			verdict.DecidedBy = DecidedByUnavailable
+		}
+		rmq.completeTransaction(ctx, verdict)
+		rmq.releaseIdempotencyKey(ctx, txnID)
+		observeVerdict(verdict)
+		return verdict, err
 	}
 
-	return rmq.rabbitMQChan.PublishWithContext(
-		ctx,
-		"transaction_requests",
-		"",
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        txnRequestByte,
-		},
+	slog.InfoContext(ctx, "transaction verdict",
+		"transaction_id", txnID,
+		"is_compliant", verdict.IsCompliant,
+		"decided_by", verdict.DecidedBy,
+		"valid_votes", verdict.ValidVotes,
+		"invalid_votes", verdict.InvalidVotes,
+	)
+	span.SetAttributes(
+		attribute.Bool("transaction.is_compliant", verdict.IsCompliant),
+		attribute.String("quorum.decided_by", verdict.DecidedBy),
+		attribute.Int("quorum.valid_votes", verdict.ValidVotes),
+		attribute.Int("quorum.invalid_votes", verdict.InvalidVotes),
+		attribute.Int("quorum.missing_votes", verdict.MissingVotes),
 	)
+	rmq.completeTransaction(ctx, verdict)
+	observeVerdict(verdict)
+	return verdict, nil
 }
 
-func (rmq *RabbitMQService) collectResponses(ctx context.Context, queueName, transactionID string) (bool, error) {
-	slog.InfoContext(ctx, "collecting responses for transaction", "transaction_id", transactionID)
-	responseChan, err := rmq.rabbitMQChan.Consume(
-		transactionID,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+// noOperators reports whether a broadcast failed because no operator could be
+// reached rather than because of an error on our side.
+func noOperators(err error) bool {
+	return errors.Is(err, broker.ErrUnroutable) || errors.Is(err, broker.ErrNacked)
+}
+
+func observeVerdict(verdict *Verdict) {
+	outcome := verdict.DecidedBy
+	if outcome == DecidedByQuorum {
+		outcome = "rejected"
+		if verdict.IsCompliant {
+			outcome = "approved"
+		}
+	}
+	metrics.Transactions.WithLabelValues(outcome).Inc()
+	metrics.QuorumDuration.WithLabelValues(verdict.DecidedBy).Observe(float64(verdict.ElapsedMS) / 1000)
+	metrics.VotesPerTransaction.Observe(float64(len(verdict.Votes)))
+}
+
+func (rmq *RabbitMQService) publishAndCollect(ctx context.Context, txnRequest TransactionRequest, policy quorum.Policy, start time.Time) (*Verdict, error) {
+	txnID := txnRequest.TransactionID
+	// Register before publishing so no vote arrives before anyone is waiting
+	// for it.
+	responses := rmq.awaitResponses(txnID)
+	defer rmq.forgetResponses(txnID)
+
+	if err := rmq.publishTransaction(ctx, txnRequest); err != nil {
+		return nil, fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	return rmq.collectResponses(ctx, responses, txnID, policy, start)
+}
+
+// notify delivers the verdict to the caller's callback URL, if one was given.
+func (rmq *RabbitMQService) notify(ctx context.Context, callbackURL string, verdict *Verdict) {
+	if callbackURL == "" {
+		return
+	}
+	if err := rmq.webhooks.Deliver(ctx, callbackURL, verdict); err != nil {
+		slog.ErrorContext(ctx, "error delivering callback", "transaction_id", verdict.TransactionID, "callback_url", callbackURL, "error", err.Error())
+		return
+	}
+	slog.InfoContext(ctx, "delivered callback", "transaction_id", verdict.TransactionID, "callback_url", callbackURL)
+}
+
+func (rmq *RabbitMQService) publishTransaction(ctx context.Context, txnRequest TransactionRequest) error {
+	txnRequestByte, err := json.Marshal(txnRequest)
 	if err != nil {
-		return false, fmt.Errorf("failed to set up response channel: %w", err)
+		return fmt.Errorf("failed to marshal transaction request: %w", err)
 	}
 
-	responses := 0
+	ctx, span := tracer.Start(ctx, "transaction_requests publish", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination.name", exchangeName),
+		attribute.String("transaction.id", txnRequest.TransactionID),
+	))
+	defer span.End()
+
+	err = rmq.broker.Publish(ctx, exchangeName, broker.Message{
+		ContentType: "application/json",
+		// Carry the request ID and trace so operator logs for this
+		// transaction can be tied back to the HTTP request.
+		Headers: correlation.Inject(ctx, nil),
+		// Operators send their votes to this dispatcher's reply queue,
+		// correlated by transaction ID.
+		ReplyTo:       rmq.replyQueue,
+		CorrelationID: txnRequest.TransactionID,
+		Body:          txnRequestByte,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	rmq.lastBroadcastAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (rmq *RabbitMQService) collectResponses(ctx context.Context, responseChan <-chan broker.Delivery, transactionID string, policy quorum.Policy, start time.Time) (*Verdict, error) {
+	slog.InfoContext(ctx, "collecting responses for transaction", "transaction_id", transactionID, "quorum_policy", policy.String())
+
+	verdict := &Verdict{
+		TransactionID: transactionID,
+		Votes:         []OperatorVote{},
+		QuorumPolicy:  policy.String(),
+	}
+	var tally quorum.Tally
+	voted := make(map[string]bool)
 	for {
 		select {
 		case response := <-responseChan:
-			if response.RoutingKey == transactionID {
-				var txnResponse TransactionResponse
-				if err := json.Unmarshal(response.Body, &txnResponse); err != nil {
-					return false, fmt.Errorf("failed to unmarshal response: %w", err)
-				}
-
-				slog.InfoContext(ctx, "received response", "transaction_id", transactionID, "is_valid", txnResponse.IsValid)
-
-				if txnResponse.IsValid {
-					responses++
-				}
-				if responses >= 5 {
-					rmq.deleteQueue(ctx, queueName)
-					return true, nil
-				}
+			var txnResponse TransactionResponse
+			if err := json.Unmarshal(response.Body, &txnResponse); err != nil {
+				// Every operator shares the reply queue, so one bad message
+				// mustn't cost the transaction the other votes.
+				slog.WarnContext(ctx, "discarding undecodable vote", "transaction_id", transactionID, "error", err.Error())
+				metrics.VotesDiscarded.WithLabelValues("invalid").Inc()
+				continue
+			}
+			span := rmq.startResponseSpan(ctx, response, txnResponse)
+
+			slog.InfoContext(ctx, "received response",
+				"transaction_id", transactionID,
+				"operator_id", txnResponse.OperatorID,
+				"is_valid", txnResponse.IsValid,
+				"reason", txnResponse.Reason,
+				"list_version", txnResponse.ListVersion,
+			)
+
+			if err := rmq.verifyVote(transactionID, txnResponse); err != nil {
+				slog.WarnContext(ctx, "discarding vote", "transaction_id", transactionID, "operator_id", txnResponse.OperatorID, "error", err.Error())
+				metrics.VotesDiscarded.WithLabelValues("invalid").Inc()
+				span.SetStatus(codes.Error, err.Error())
+				span.End()
+				continue
+			}
+			if voted[txnResponse.OperatorID] {
+				slog.WarnContext(ctx, "discarding duplicate vote", "transaction_id", transactionID, "operator_id", txnResponse.OperatorID)
+				metrics.VotesDiscarded.WithLabelValues("duplicate").Inc()
+				span.SetStatus(codes.Error, "duplicate vote")
+				span.End()
+				continue
+			}
+			voted[txnResponse.OperatorID] = true
+			rmq.lastVoteAt.Store(time.Now().UnixNano())
+
+			tally.Add(quorum.Vote{OperatorID: txnResponse.OperatorID, IsValid: txnResponse.IsValid})
+			operatorVote := OperatorVote{
+				OperatorID:  txnResponse.OperatorID,
+				IsValid:     txnResponse.IsValid,
+				Reason:      txnResponse.Reason,
+				ListVersion: txnResponse.ListVersion,
+				ReceivedAt:  time.Now().UTC(),
+			}
+			verdict.Votes = append(verdict.Votes, operatorVote)
+			rmq.recordVote(ctx, transactionID, operatorVote, txnResponse.Signature)
+			span.End()
+
+			if outcome := policy.Decide(tally); outcome != quorum.Pending {
+				verdict.IsCompliant = outcome == quorum.Approved
+				verdict.DecidedBy = DecidedByQuorum
+				verdict.finish(tally, len(rmq.operatorKeys), start)
+				return verdict, nil
 			}
 		case <-ctx.Done():
-			rmq.deleteQueue(ctx, queueName)
-			return false, nil
+			verdict.DecidedBy = DecidedByTimeout
+			verdict.finish(tally, len(rmq.operatorKeys), start)
+			return verdict, nil
 		}
 	}
 }
 
-func (rmq *RabbitMQService) deleteQueue(ctx context.Context, queueName string) {
-	_, err := rmq.rabbitMQChan.QueueDelete(queueName, false, false, false)
-	if err != nil {
-		slog.ErrorContext(ctx, "failed to delete queue", "queueName", queueName, "error",err)
+// startResponseSpan starts a span for one operator's response, linked to the
+// operator's processing span carried in the message headers.
+func (rmq *RabbitMQService) startResponseSpan(ctx context.Context, response broker.Delivery, txnResponse TransactionResponse) trace.Span {
+	operatorCtx := otel.GetTextMapPropagator().Extract(context.Background(), tracing.HeaderCarrier(response.Headers))
+	_, span := tracer.Start(ctx, "transaction response receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(trace.LinkFromContext(operatorCtx)),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("transaction.id", txnResponse.TransactionID),
+			attribute.String("operator.id", txnResponse.OperatorID),
+			attribute.Bool("vote.is_valid", txnResponse.IsValid),
+			attribute.String("vote.reason", txnResponse.Reason),
+		),
+	)
+	return span
+}
+
+// verifyVote checks that a response comes from a known operator and carries a
+// valid signature over its verdict, reason and list version for this
+// transaction.
+func (rmq *RabbitMQService) verifyVote(transactionID string, resp TransactionResponse) error {
+	if resp.OperatorID == "" {
+		return fmt.Errorf("vote has no operator id")
+	}
+	if resp.TransactionID != transactionID {
+		return fmt.Errorf("vote is for transaction %s", resp.TransactionID)
 	}
+	if len(rmq.operatorKeys) == 0 {
+		return nil
+	}
+
+	key, ok := rmq.operatorKeys[resp.OperatorID]
+	if !ok {
+		return fmt.Errorf("unknown operator")
+	}
+	signed := vote.Vote{
+		OperatorID:    resp.OperatorID,
+		TransactionID: resp.TransactionID,
+		IsValid:       resp.IsValid,
+		Reason:        resp.Reason,
+		ListVersion:   resp.ListVersion,
+	}
+	if !vote.Verify(key, signed, resp.Signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
 }
 
-func failOnError(err error, msg string) {
-	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
+// finish fills in the vote counts. operators is how many operators may vote;
+// missing votes are left at 0 when it isn't known.
+func (v *Verdict) finish(tally quorum.Tally, operators int, start time.Time) {
+	v.ValidVotes = tally.Valid
+	v.InvalidVotes = tally.Invalid
+	v.MissingVotes = max(operators-tally.Valid-tally.Invalid, 0)
+	v.ElapsedMS = time.Since(start).Milliseconds()
+}
+
+// Drain waits for async broadcasts and callback deliveries to finish, or for
+// ctx to be done.
+func (rmq *RabbitMQService) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		rmq.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 func (rmq *RabbitMQService) Close() {
-	rmq.rabbitMQChan.Close()
-	rmq.rabbitMQConn.Close()
+	rmq.broker.Close()
 }
 
 func sendJSONResponse(w http.ResponseWriter, data interface{}) {
@@ -0,0 +1,94 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/metrics"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/broker"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/rmqconn"
+)
+
+// replyQueuePrefix names the queue operators send their votes to. Every
+// dispatcher gets its own with a random suffix.
+const replyQueuePrefix = "dispatcher.replies."
+
+// pendingBuffer is how many responses can wait for one transaction's collector
+// before more are dropped. It is well above any realistic number of operators.
+const pendingBuffer = 64
+
+// awaitResponses registers a transaction so responses correlated with it are
+// handed to the returned channel. It must be called before the transaction is
+// published and undone with forgetResponses.
+func (rmq *RabbitMQService) awaitResponses(txnID string) <-chan broker.Delivery {
+	responses := make(chan broker.Delivery, pendingBuffer)
+
+	rmq.pendingMu.Lock()
+	defer rmq.pendingMu.Unlock()
+	rmq.pending[txnID] = responses
+	return responses
+}
+
+func (rmq *RabbitMQService) forgetResponses(txnID string) {
+	rmq.pendingMu.Lock()
+	defer rmq.pendingMu.Unlock()
+	delete(rmq.pending, txnID)
+}
+
+// consumeReplies routes responses from the reply queue to the transactions
+// waiting for them until ctx is done. The reply queue goes away with the
+// connection, so it is declared again after RabbitMQ reconnects; votes sent
+// in between are lost and those transactions time out.
+func (rmq *RabbitMQService) consumeReplies(ctx context.Context, responses <-chan broker.Delivery) {
+	for {
+		for d := range responses {
+			rmq.routeResponse(ctx, d)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "reply consumer stopped, waiting for rabbitmq to reconnect")
+
+		for {
+			var err error
+			responses, err = rmq.broker.ConsumeReplies(ctx, rmq.replyQueue)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil || errors.Is(err, rmqconn.ErrNotConnected) {
+				return
+			}
+			// The channel may have died before the supervisor noticed; give
+			// it a moment to reconnect.
+			slog.WarnContext(ctx, "failed to consume replies, retrying", "error", err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// routeResponse hands a response to the transaction it is correlated with.
+// Responses for transactions that already have a verdict are dropped.
+func (rmq *RabbitMQService) routeResponse(ctx context.Context, d broker.Delivery) {
+	rmq.pendingMu.Lock()
+	responses, ok := rmq.pending[d.CorrelationID]
+	rmq.pendingMu.Unlock()
+
+	if !ok {
+		slog.InfoContext(ctx, "discarding response for a transaction that is no longer waiting", "transaction_id", d.CorrelationID)
+		metrics.VotesDiscarded.WithLabelValues("late").Inc()
+		return
+	}
+
+	select {
+	case responses <- d:
+	default:
+		slog.WarnContext(ctx, "discarding response, too many waiting", "transaction_id", d.CorrelationID)
+		metrics.VotesDiscarded.WithLabelValues("overflow").Inc()
+	}
+}
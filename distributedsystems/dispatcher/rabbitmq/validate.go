@@ -0,0 +1,152 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxRequestBodyBytes caps the size of a POST /transaction body.
+const maxRequestBodyBytes = 64 << 10
+
+// maxIdempotencyKeyBytes matches the ledger's idempotency_key column.
+const maxIdempotencyKeyBytes = 255
+
+var (
+	addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+	txnHashPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+)
+
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type validationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// decodeSubmission strictly decodes a POST /transaction body: it must be a
+// single JSON object under maxRequestBodyBytes with no unknown fields.
+func decodeSubmission(w http.ResponseWriter, r *http.Request) (transactionSubmission, error) {
+	var submission transactionSubmission
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&submission); err != nil {
+		return submission, err
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return submission, fmt.Errorf("request body must contain a single JSON object")
+	}
+	return submission, nil
+}
+
+// writeDecodeError answers a body that could not be decoded.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeValidationError(w, http.StatusRequestEntityTooLarge, validationErrorResponse{
+			Error: fmt.Sprintf("request body must not exceed %d bytes", maxBytesErr.Limit),
+		})
+		return
+	}
+
+	resp := validationErrorResponse{Error: "invalid request body"}
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		resp.Fields = []FieldError{{Field: typeErr.Field, Message: "must be of type " + typeErr.Type.String()}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		resp.Fields = []FieldError{{Field: field, Message: "unknown field"}}
+	default:
+		resp.Error += ": " + err.Error()
+	}
+	writeValidationError(w, http.StatusBadRequest, resp)
+}
+
+// validate checks every field of a submission and returns all the problems
+// found, so callers can fix them in one go.
+func (rmq *RabbitMQService) validate(submission transactionSubmission) []FieldError {
+	var errs []FieldError
+	add := func(field, message string) {
+		errs = append(errs, FieldError{Field: field, Message: message})
+	}
+
+	txn := submission.TransactionRequest
+	if txn.TransactionID != "" {
+		add("transaction_id", "must not be set, it is assigned by the dispatcher")
+	}
+
+	switch {
+	case txn.TxnHash == "":
+		add("txn_hash", "is required")
+	case !txnHashPattern.MatchString(txn.TxnHash):
+		add("txn_hash", "must be a 0x-prefixed 64 character hex string")
+	}
+
+	for _, f := range []struct{ name, value string }{{"from", txn.From}, {"to", txn.To}} {
+		switch {
+		case f.value == "":
+			add(f.name, "is required")
+		case !addressPattern.MatchString(f.value):
+			add(f.name, "must be a 0x-prefixed 40 character hex address")
+		}
+	}
+	if txn.From != "" && strings.EqualFold(txn.From, txn.To) {
+		add("to", "must differ from from")
+	}
+
+	switch {
+	case txn.Value <= 0:
+		add("value", "must be greater than 0")
+	case rmq.maxTransactionValue > 0 && txn.Value > rmq.maxTransactionValue:
+		add("value", fmt.Sprintf("must not exceed %d", rmq.maxTransactionValue))
+	}
+
+	if submission.CallbackURL != "" {
+		if err := rmq.webhooks.ValidateURL(submission.CallbackURL); err != nil {
+			add("callback_url", err.Error())
+		}
+	}
+
+	return errs
+}
+
+// validateIdempotencyKey checks the Idempotency-Key header, if one was sent:
+// it must be 1 to maxIdempotencyKeyBytes printable ASCII characters.
+func validateIdempotencyKey(header http.Header) []FieldError {
+	values := header.Values("Idempotency-Key")
+	if len(values) == 0 {
+		return nil
+	}
+
+	key := values[0]
+	var message string
+	switch {
+	case len(values) > 1:
+		message = "must be sent once"
+	case key == "":
+		message = "must not be empty"
+	case len(key) > maxIdempotencyKeyBytes:
+		message = fmt.Sprintf("must not exceed %d bytes", maxIdempotencyKeyBytes)
+	case strings.IndexFunc(key, func(r rune) bool { return r < '!' || r > '~' }) >= 0:
+		message = "must only contain printable ASCII characters"
+	default:
+		return nil
+	}
+	return []FieldError{{Field: "Idempotency-Key", Message: message}}
+}
+
+func writeValidationError(w http.ResponseWriter, status int, resp validationErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
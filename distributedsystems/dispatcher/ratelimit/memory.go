@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// MemoryStore keeps buckets in process. Every dispatcher replica enforces
+// its own budget.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	limit    Limit
+	lastSeen time.Time
+}
+
+// NewMemoryStore returns a MemoryStore that drops buckets unused for idleTTL
+// until ctx is cancelled. A bucket that has been idle that long is full
+// anyway, so dropping it doesn't change anyone's budget.
+func NewMemoryStore(ctx context.Context, idleTTL time.Duration) *MemoryStore {
+	s := &MemoryStore{buckets: make(map[string]*bucket)}
+	go s.evict(ctx, idleTTL)
+	return s
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst), limit: limit}
+		s.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	d := Decision{Limit: limit}
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		d.RetryAfter = delay
+	} else {
+		d.Allowed = true
+	}
+
+	tokens := b.limiter.TokensAt(now)
+	d.Remaining = max(int(math.Floor(tokens)), 0)
+	d.Reset = time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second))
+	return d, nil
+}
+
+func (s *MemoryStore) evict(ctx context.Context, idleTTL time.Duration) {
+	ticker := time.NewTicker(idleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, b := range s.buckets {
+				if now.Sub(b.lastSeen) > idleTTL {
+					delete(s.buckets, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
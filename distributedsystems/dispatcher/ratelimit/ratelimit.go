@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at Rate per
+// second.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Policy formats the limit for the RateLimit-Policy header: the burst and the
+// time in seconds it takes an empty bucket to fill up again.
+func (l Limit) Policy() string {
+	return fmt.Sprintf("%d;w=%d", l.Burst, int(math.Ceil(float64(l.Burst)/l.Rate)))
+}
+
+// Decision is the outcome of taking one request from a client's bucket.
+type Decision struct {
+	Allowed bool
+	// Tier is the name of the tier the client was checked against.
+	Tier  string
+	Limit Limit
+	// Remaining is how many more requests the client can make right now.
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed. It is
+	// zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// Store holds the buckets for every client.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// DefaultTier is used for clients that aren't assigned a tier.
+const DefaultTier = "default"
+
+// Default keeps the limit the dispatcher always had, now per client.
+var Default = Limit{Rate: 2, Burst: 10}
+
+// Limiter assigns clients to tiers and checks them against their tier's limit.
+type Limiter struct {
+	store   Store
+	tiers   map[string]Limit
+	clients map[string]string
+}
+
+// New returns a Limiter. tiers maps a tier name to its limit and clients maps
+// a client key to a tier name; clients without an entry use DefaultTier,
+// which falls back to Default when not in tiers.
+func New(store Store, tiers map[string]Limit, clients map[string]string) (*Limiter, error) {
+	t := map[string]Limit{DefaultTier: Default}
+	for name, limit := range tiers {
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			return nil, fmt.Errorf("tier %s: rate must be positive and burst at least 1", name)
+		}
+		t[name] = limit
+	}
+	for client, tier := range clients {
+		if _, ok := t[tier]; !ok {
+			return nil, fmt.Errorf("client %s is assigned to unknown tier %s", client, tier)
+		}
+	}
+	return &Limiter{store: store, tiers: t, clients: clients}, nil
+}
+
+// Allow takes one request from key's bucket.
+func (l *Limiter) Allow(ctx context.Context, key string) (Decision, error) {
+	tier, ok := l.clients[key]
+	if !ok {
+		tier = DefaultTier
+	}
+	limit := l.tiers[tier]
+	d, err := l.store.Allow(ctx, tier+":"+key, limit)
+	d.Tier = tier
+	return d, err
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcra implements the generic cell rate algorithm, which behaves like a token
+// bucket but only stores one timestamp per client: the theoretical arrival
+// time (TAT) of the next request. Redis's clock is used so replicas with
+// skewed clocks still agree.
+//
+// KEYS[1] client key, ARGV[1] ms per token, ARGV[2] burst.
+// Returns {allowed, remaining, retry_after_ms, reset_ms}.
+var gcra = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+  tat = now
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - burst * interval
+if allow_at > now then
+  local remaining = math.floor((now - (tat - burst * interval)) / interval)
+  return {0, math.max(remaining, 0), math.ceil(allow_at - now), math.ceil(tat - now)}
+end
+
+redis.call('SET', KEYS[1], new_tat, 'PX', math.ceil(new_tat - now))
+local remaining = math.floor((now - allow_at) / interval)
+return {1, remaining, 0, math.ceil(new_tat - now)}
+`)
+
+// RedisStore keeps buckets in Redis so every dispatcher replica shares one
+// budget per client. Keys expire once their bucket is full, so idle clients
+// are evicted by Redis.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to the Redis at url, e.g. redis://localhost:6379/0.
+func NewRedisStore(ctx context.Context, url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return &RedisStore{client: client, prefix: "ratelimit:"}, nil
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	interval := 1000 / limit.Rate
+	res, err := gcra.Run(ctx, s.client, []string{s.prefix + key}, interval, limit.Burst).Int64Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("error running rate limit script: %w", err)
+	}
+
+	return Decision{
+		Allowed:    res[0] == 1,
+		Limit:      limit,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		Reset:      time.Duration(res[3]) * time.Millisecond,
+	}, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rasha-hantash/golang/distributedsystems/libs/correlation"
+)
+
+// errPrivateAddress is returned for callbacks to loopback, link-local or
+// private addresses, which would let callers reach the dispatcher's internal
+// network.
+var errPrivateAddress = errors.New("callback url must not point to a private address")
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), private in all but
+// name.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Client delivers verdicts to caller supplied callback URLs, retrying with
+// exponential backoff when the receiver is unavailable.
+type Client struct {
+	httpClient           *http.Client
+	maxAttempts          int
+	initialBackoff       time.Duration
+	allowPrivateNetworks bool
+}
+
+type Config struct {
+	// AllowPrivateNetworks allows callbacks to loopback, link-local and
+	// private addresses, e.g. for a receiver in a test. It must not be set
+	// when callers are untrusted.
+	AllowPrivateNetworks bool
+}
+
+func NewClient(cfg Config) *Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !cfg.AllowPrivateNetworks {
+		// Check the address actually dialled, after DNS resolution, so a
+		// host that resolves to an internal address, or is rebound to one
+		// after ValidateURL, is still refused.
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would be dialled instead of the receiver and defeat the check
+	// above.
+	transport.Proxy = nil
+
+	return &Client{
+		httpClient:           &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		maxAttempts:          5,
+		initialBackoff:       time.Second,
+		allowPrivateNetworks: cfg.AllowPrivateNetworks,
+	}
+}
+
+// ValidateURL checks that a callback URL is an absolute http(s) URL that
+// doesn't name a loopback, link-local or private host. Hostnames are checked
+// again once resolved, when the callback is delivered.
+func (c *Client) ValidateURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("callback url must use http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("callback url must have a host")
+	}
+	if c.allowPrivateNetworks {
+		return nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errPrivateAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// publicAddr reports whether addr is a globally routable unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// Deliver POSTs payload as JSON to callbackURL. Network errors, 429s and 5xx
+// responses are retried; any other non-2xx response is treated as permanent.
+func (c *Client) Deliver(ctx context.Context, callbackURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding payload: %w", err)
+	}
+
+	backoff := c.initialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := c.post(ctx, callbackURL, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == c.maxAttempts {
+			return fmt.Errorf("callback failed after %d attempts: %w", attempt, err)
+		}
+
+		slog.WarnContext(ctx, "callback attempt failed, retrying",
+			"callback_url", callbackURL,
+			"attempt", attempt,
+			"backoff", backoff.String(),
+			"error", err.Error(),
+		)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("callback cancelled: %w", ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) post(ctx context.Context, callbackURL string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	correlation.InjectHTTP(ctx, req.Header)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return !errors.Is(err, errPrivateAddress), fmt.Errorf("error sending request: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
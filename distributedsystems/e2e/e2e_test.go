@@ -0,0 +1,353 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/quorum"
+	dispatcher "github.com/rasha-hantash/golang/distributedsystems/dispatcher/rabbitmq"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/broker"
+	"github.com/rasha-hantash/golang/distributedsystems/operator/compliance"
+)
+
+const (
+	from = "0x23618e81E3f5cdF7f54C3d65f7FBc0aBf5B21E8f"
+	to   = "0x8A791620dd6260079BF849Dc5567aDC3F2FdC318"
+)
+
+var txnCounter atomic.Int64
+
+// newTransaction returns a valid request with a txn_hash no other test uses,
+// so idempotency keys don't collide.
+func newTransaction() dispatcher.TransactionRequest {
+	return dispatcher.TransactionRequest{
+		TxnHash: fmt.Sprintf("0x%064x", txnCounter.Add(1)),
+		From:    from,
+		To:      to,
+		Value:   1000,
+	}
+}
+
+// slowValidator allows every transaction after a delay.
+type slowValidator time.Duration
+
+func (v slowValidator) Validate(ctx context.Context, txn compliance.Transaction) compliance.Result {
+	time.Sleep(time.Duration(v))
+	return compliance.Allow()
+}
+
+func start(t *testing.T, cfg Config) *Harness {
+	t.Helper()
+	h, err := Start(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("starting harness: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// post submits body to POST /transaction?query with the given headers.
+func post(t *testing.T, h *Harness, body any, query string, header http.Header) response {
+	t.Helper()
+	res, err := submit(h, body, query, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// submit is post for goroutines other than the test's, which can't call
+// t.Fatal.
+func submit(h *Harness, body any, query string, header http.Header) (response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return response{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL+"/transaction?"+query, bytes.NewReader(b))
+	if err != nil {
+		return response{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return response{}, err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return response{}, err
+	}
+	return response{status: res.StatusCode, header: res.Header, body: resBody}, nil
+}
+
+func (r response) verdict(t *testing.T) dispatcher.Verdict {
+	t.Helper()
+	if r.status != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", r.status, r.body)
+	}
+	var verdict dispatcher.Verdict
+	if err := json.Unmarshal(r.body, &verdict); err != nil {
+		t.Fatalf("decoding verdict: %v", err)
+	}
+	return verdict
+}
+
+func TestApproved(t *testing.T) {
+	h := start(t, Config{Operators: 3})
+
+	verdict, err := h.Submit(context.Background(), newTransaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verdict.IsCompliant || verdict.DecidedBy != dispatcher.DecidedByQuorum {
+		t.Errorf("got compliant %t decided by %s, want compliant by quorum", verdict.IsCompliant, verdict.DecidedBy)
+	}
+	if verdict.ValidVotes != 3 || verdict.MissingVotes != 0 {
+		t.Errorf("got %d valid and %d missing votes, want 3 and 0", verdict.ValidVotes, verdict.MissingVotes)
+	}
+	for _, v := range verdict.Votes {
+		if v.Reason != string(compliance.ReasonOK) {
+			t.Errorf("operator %s voted with reason %q, want ok", v.OperatorID, v.Reason)
+		}
+	}
+}
+
+func TestRejectedWithoutWaitingForTimeout(t *testing.T) {
+	h := start(t, Config{
+		Operators: 3,
+		Validator: func(operatorID string) compliance.Validator {
+			if operatorID == "operator-2" {
+				return compliance.NewDenyList([]string{to})
+			}
+			return compliance.Chain{}
+		},
+	})
+
+	verdict, err := h.Submit(context.Background(), newTransaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.IsCompliant || verdict.DecidedBy != dispatcher.DecidedByQuorum {
+		t.Errorf("got compliant %t decided by %s, want rejected by quorum", verdict.IsCompliant, verdict.DecidedBy)
+	}
+	if verdict.ElapsedMS >= dispatcher.DefaultResponseTimeout.Milliseconds() {
+		t.Errorf("took %dms, want a rejection before the response window ran out", verdict.ElapsedMS)
+	}
+}
+
+func TestDefaultPolicyToleratesInvalidVotesFromSpareOperators(t *testing.T) {
+	h := start(t, Config{
+		Operators: 6,
+		Validator: func(operatorID string) compliance.Validator {
+			if operatorID == "operator-2" {
+				return compliance.NewDenyList([]string{to})
+			}
+			return compliance.Chain{}
+		},
+		QuorumPolicy: quorum.Default(6),
+	})
+
+	verdict, err := h.Submit(context.Background(), newTransaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verdict.IsCompliant || verdict.ValidVotes != 5 {
+		t.Errorf("got compliant %t with %d valid votes, want compliant with 5", verdict.IsCompliant, verdict.ValidVotes)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := start(t, Config{
+		Operators:       3,
+		Validator:       func(string) compliance.Validator { return slowValidator(500 * time.Millisecond) },
+		ResponseTimeout: 100 * time.Millisecond,
+	})
+
+	verdict, err := h.Submit(context.Background(), newTransaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.IsCompliant || verdict.DecidedBy != dispatcher.DecidedByTimeout {
+		t.Errorf("got compliant %t decided by %s, want not compliant by timeout", verdict.IsCompliant, verdict.DecidedBy)
+	}
+	if verdict.MissingVotes != 3 {
+		t.Errorf("got %d missing votes, want 3", verdict.MissingVotes)
+	}
+}
+
+func TestOperatorStopped(t *testing.T) {
+	h := start(t, Config{Operators: 3, ResponseTimeout: 300 * time.Millisecond})
+	if err := h.StopOperator("operator-3"); err != nil {
+		t.Fatal(err)
+	}
+
+	verdict, err := h.Submit(context.Background(), newTransaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.IsCompliant || verdict.DecidedBy != dispatcher.DecidedByTimeout {
+		t.Errorf("got compliant %t decided by %s, want not compliant by timeout", verdict.IsCompliant, verdict.DecidedBy)
+	}
+	if verdict.ValidVotes != 2 || verdict.MissingVotes != 1 {
+		t.Errorf("got %d valid and %d missing votes, want 2 and 1", verdict.ValidVotes, verdict.MissingVotes)
+	}
+}
+
+func TestNoOperators(t *testing.T) {
+	h := start(t, Config{Operators: 1})
+	if err := h.StopOperator("operator-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := newTransaction()
+	// The retry must be broadcast again rather than replay the failure.
+	for attempt := 1; attempt <= 2; attempt++ {
+		res := post(t, h, txn, "", nil)
+		if res.status != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d: got %d, want 503: %s", attempt, res.status, res.body)
+		}
+		if res.header.Get("Retry-After") == "" {
+			t.Errorf("attempt %d: no Retry-After", attempt)
+		}
+	}
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	h := start(t, Config{Operators: 3})
+
+	txn := newTransaction()
+	first := post(t, h, txn, "", nil).verdict(t)
+	retry := post(t, h, txn, "", nil)
+	if got := retry.header.Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("got Idempotent-Replayed %q, want true", got)
+	}
+	if replayed := retry.verdict(t); replayed.TransactionID != first.TransactionID {
+		t.Errorf("retry got transaction %s, want %s", replayed.TransactionID, first.TransactionID)
+	}
+
+	reused := txn
+	reused.Value++
+	if res := post(t, h, reused, "", nil); res.status != http.StatusUnprocessableEntity {
+		t.Errorf("reusing the key for another transaction got %d, want 422", res.status)
+	}
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	h := start(t, Config{Operators: 3})
+
+	header := http.Header{"Idempotency-Key": {strings.Repeat("k", 256)}}
+	if res := post(t, h, newTransaction(), "", header); res.status != http.StatusBadRequest {
+		t.Errorf("got %d, want 400: %s", res.status, res.body)
+	}
+}
+
+func TestRetryWaitsForInFlightTransaction(t *testing.T) {
+	h := start(t, Config{
+		Operators: 3,
+		Validator: func(string) compliance.Validator { return slowValidator(300 * time.Millisecond) },
+	})
+
+	// The original claims the key before broadcasting, so once the broadcast
+	// is out the retry finds it in flight for as long as the operators take.
+	broadcast := make(chan struct{})
+	var once sync.Once
+	h.Broker.OnPublish(func(string, broker.Message) { once.Do(func() { close(broadcast) }) })
+
+	type result struct {
+		res response
+		err error
+	}
+	originalDone := make(chan result, 1)
+	txn := newTransaction()
+	go func() {
+		res, err := submit(h, txn, "", nil)
+		originalDone <- result{res, err}
+	}()
+
+	select {
+	case <-broadcast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction was never broadcast")
+	}
+	retryRes := post(t, h, txn, "", nil)
+	original := <-originalDone
+	if original.err != nil {
+		t.Fatal(original.err)
+	}
+
+	originalVerdict, retry := original.res.verdict(t), retryRes.verdict(t)
+	if retry.TransactionID != originalVerdict.TransactionID {
+		t.Errorf("retry got transaction %s, want %s", retry.TransactionID, originalVerdict.TransactionID)
+	}
+	if retry.DecidedBy != dispatcher.DecidedByQuorum {
+		t.Errorf("retry got a verdict decided by %q, want quorum", retry.DecidedBy)
+	}
+	if got := retryRes.header.Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("got Idempotent-Replayed %q, want true", got)
+	}
+}
+
+func TestAsyncCallbackAfterTimeout(t *testing.T) {
+	callbacks := make(chan dispatcher.Verdict, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var verdict dispatcher.Verdict
+		if err := json.NewDecoder(r.Body).Decode(&verdict); err != nil {
+			t.Errorf("decoding callback: %v", err)
+		}
+		callbacks <- verdict
+	}))
+	defer receiver.Close()
+
+	h := start(t, Config{
+		Operators:       3,
+		Validator:       func(string) compliance.Validator { return slowValidator(500 * time.Millisecond) },
+		ResponseTimeout: 100 * time.Millisecond,
+	})
+
+	submission := struct {
+		dispatcher.TransactionRequest
+		CallbackURL string `json:"callback_url"`
+	}{newTransaction(), receiver.URL}
+	res := post(t, h, submission, "async=true", nil)
+	if res.status != http.StatusAccepted {
+		t.Fatalf("got %d, want 202: %s", res.status, res.body)
+	}
+
+	select {
+	case verdict := <-callbacks:
+		if verdict.DecidedBy != dispatcher.DecidedByTimeout {
+			t.Errorf("callback got a verdict decided by %q, want timeout", verdict.DecidedBy)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no callback received")
+	}
+}
+
+func TestQuorumOverrideNotAllowed(t *testing.T) {
+	h := start(t, Config{Operators: 3})
+
+	res := post(t, h, newTransaction(), "quorum=absolute:1", nil)
+	if res.status != http.StatusBadRequest {
+		t.Errorf("got %d, want 400: %s", res.status, res.body)
+	}
+}
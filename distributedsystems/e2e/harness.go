@@ -0,0 +1,230 @@
+// Package e2e runs a dispatcher and simulated operators in one process over an
+// in-memory broker, so the whole transaction flow can be exercised from a test
+// without RabbitMQ:
+//
+//	h, err := e2e.Start(ctx, e2e.Config{Operators: 3})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer h.Close()
+//
+//	verdict, err := h.Submit(ctx, dispatcher.TransactionRequest{...})
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/quorum"
+	dispatcher "github.com/rasha-hantash/golang/distributedsystems/dispatcher/rabbitmq"
+	"github.com/rasha-hantash/golang/distributedsystems/dispatcher/webhook"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/broker"
+	"github.com/rasha-hantash/golang/distributedsystems/operator/compliance"
+	operator "github.com/rasha-hantash/golang/distributedsystems/operator/rabbitmq"
+)
+
+// readyTimeout bounds how long Start waits for the operators to subscribe.
+const readyTimeout = 5 * time.Second
+
+type Config struct {
+	// Operators is how many operators to run. Defaults to 5.
+	Operators int
+	// Validator returns the compliance rules for an operator. Defaults to
+	// allowing every transaction.
+	Validator func(operatorID string) compliance.Validator
+	// QuorumPolicy defaults to requiring a valid vote from every operator and
+	// rejecting on the first invalid one.
+	QuorumPolicy quorum.Policy
+	// MaxTransactionValue is passed to the dispatcher.
+	MaxTransactionValue int64
+	// ResponseTimeout is how long the dispatcher waits for a verdict.
+	// Defaults to the dispatcher's default.
+	ResponseTimeout time.Duration
+}
+
+// Harness is a running dispatcher and its operators. The dispatcher's API is
+// served at URL.
+type Harness struct {
+	URL        string
+	Broker     *broker.Memory
+	Dispatcher *dispatcher.RabbitMQService
+	// OperatorIDs are operator-1 to operator-N.
+	OperatorIDs []string
+
+	server *httptest.Server
+	// stopDispatcher stops the dispatcher consuming votes.
+	stopDispatcher context.CancelFunc
+
+	mu        sync.Mutex
+	operators map[string]*runningOperator
+}
+
+type runningOperator struct {
+	svc    *operator.RabbitMQService
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// Start starts the dispatcher and operators and waits for every operator to
+// subscribe to transactions.
+func Start(ctx context.Context, cfg Config) (*Harness, error) {
+	if cfg.Operators <= 0 {
+		cfg.Operators = 5
+	}
+	if cfg.Validator == nil {
+		cfg.Validator = func(string) compliance.Validator { return compliance.Chain{} }
+	}
+	if cfg.QuorumPolicy == nil {
+		cfg.QuorumPolicy = quorum.Absolute{Required: cfg.Operators, Operators: cfg.Operators}
+	}
+
+	h := &Harness{
+		Broker:    broker.NewMemory(),
+		operators: make(map[string]*runningOperator),
+	}
+
+	operatorKeys := make(map[string]ed25519.PublicKey, cfg.Operators)
+	for i := 1; i <= cfg.Operators; i++ {
+		operatorID := fmt.Sprintf("operator-%d", i)
+		publicKey, signingKey, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		operatorKeys[operatorID] = publicKey
+
+		svc := operator.NewService(h.Broker, operator.RabbitMQConfig{
+			OperatorID: operatorID,
+			SigningKey: signingKey,
+			Validator:  cfg.Validator(operatorID),
+		})
+		opCtx, cancel := context.WithCancel(ctx)
+		op := &runningOperator{svc: svc, cancel: cancel, done: make(chan error, 1)}
+		go func() {
+			op.done <- svc.ProcessTransactions(opCtx)
+		}()
+
+		h.operators[operatorID] = op
+		h.OperatorIDs = append(h.OperatorIDs, operatorID)
+	}
+
+	dispatcherCtx, cancel := context.WithCancel(ctx)
+	h.stopDispatcher = cancel
+	var err error
+	h.Dispatcher, err = dispatcher.NewService(dispatcherCtx, h.Broker, dispatcher.RabbitMQConfig{
+		QuorumPolicy:        cfg.QuorumPolicy,
+		OperatorKeys:        operatorKeys,
+		MaxTransactionValue: cfg.MaxTransactionValue,
+		ResponseTimeout:     cfg.ResponseTimeout,
+		// Callback receivers in tests listen on loopback.
+		Webhooks: webhook.NewClient(webhook.Config{AllowPrivateNetworks: true}),
+	})
+	if err != nil {
+		cancel()
+		h.stopOperators()
+		return nil, err
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/transaction", h.Dispatcher.BroadcastTransaction).Methods("POST")
+	router.HandleFunc("/transaction/{id}", h.Dispatcher.GetTransaction).Methods("GET")
+	h.server = httptest.NewServer(router)
+	h.URL = h.server.URL
+
+	if err := h.waitForOperators(ctx, cfg.Operators); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Harness) waitForOperators(ctx context.Context, n int) error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for h.Broker.Subscribers("transaction_requests") < n {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operators did not subscribe: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// StopOperator shuts an operator down so it stops voting, e.g. to test
+// timeouts.
+func (h *Harness) StopOperator(operatorID string) error {
+	h.mu.Lock()
+	op, ok := h.operators[operatorID]
+	delete(h.operators, operatorID)
+	h.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("operator %s is not running", operatorID)
+	}
+	op.cancel()
+	return <-op.done
+}
+
+// Submit posts a transaction to the dispatcher and returns its verdict.
+func (h *Harness) Submit(ctx context.Context, txnRequest dispatcher.TransactionRequest) (*dispatcher.Verdict, error) {
+	body, err := json.Marshal(txnRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transaction request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL+"/transaction", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("dispatcher returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var verdict dispatcher.Verdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return nil, fmt.Errorf("failed to decode verdict: %w", err)
+	}
+	return &verdict, nil
+}
+
+// Close stops the operators, the dispatcher and the broker.
+func (h *Harness) Close() {
+	h.server.Close()
+	h.stopOperators()
+	h.Dispatcher.Drain(context.Background())
+	h.stopDispatcher()
+	h.Broker.Close()
+}
+
+func (h *Harness) stopOperators() {
+	h.mu.Lock()
+	operators := h.operators
+	h.operators = nil
+	h.mu.Unlock()
+
+	for _, op := range operators {
+		op.cancel()
+		<-op.done
+	}
+}
@@ -1,12 +1,8 @@
-package auth 
+package auth
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-
+	"context"
+	"time"
 )
 
 type Auth0Config struct {
@@ -16,52 +12,38 @@ type Auth0Config struct {
 	Audience     string
 }
 
-func GetAuth0Token(config Auth0Config) (string, error) {
-	url := config.Domain + "/oauth/token"
-	
-	payload := map[string]string{
-		"client_id":     config.ClientID,
-		"client_secret": config.ClientSecret,
-		"audience":      config.Audience,
-		"grant_type":    "client_credentials",
-	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling payload: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
-
-	req.Header.Add("Content-Type", "application/json")
+// Token is an access token and the time it stops being valid.
+type Token struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
 
-	client := &http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %v", err)
-	}
-	defer res.Body.Close()
+// defaultTokenLifetime is assumed when the token endpoint does not say how long
+// a token lasts.
+const defaultTokenLifetime = 5 * time.Minute
 
-	body, err := io.ReadAll(res.Body)
+func GetAuth0Token(config Auth0Config) (string, error) {
+	token, err := FetchAuth0Token(config)
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %v", err)
-	}
-
-	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, string(body))
+		return "", err
 	}
+	return token.AccessToken, nil
+}
 
-	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
-	}
+// FetchAuth0Token requests a new client credentials token from Auth0.
+func FetchAuth0Token(config Auth0Config) (Token, error) {
+	return FetchClientCredentialsToken(context.Background(), config.ClientCredentials())
+}
 
-	err = json.Unmarshal(body, &tokenResponse)
-	if err != nil {
-		return "", fmt.Errorf("error unmarshaling response: %v", err)
+// ClientCredentials describes Auth0's token endpoint, which takes a JSON body
+// with the client secret and audience in it.
+func (config Auth0Config) ClientCredentials() ClientCredentialsConfig {
+	return ClientCredentialsConfig{
+		TokenURL:     config.Domain + "/oauth/token",
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		Audience:     config.Audience,
+		AuthStyle:    AuthStyleSecretPost,
+		BodyFormat:   BodyFormatJSON,
 	}
-
-	return tokenResponse.AccessToken, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthStyle is how the client authenticates to the token endpoint.
+type AuthStyle string
+
+const (
+	// AuthStyleSecretPost sends the client secret in the request body.
+	AuthStyleSecretPost AuthStyle = "client_secret_post"
+	// AuthStyleSecretBasic sends the client ID and secret as HTTP basic auth.
+	AuthStyleSecretBasic AuthStyle = "client_secret_basic"
+	// AuthStylePrivateKeyJWT signs a client assertion with PrivateKey.
+	AuthStylePrivateKeyJWT AuthStyle = "private_key_jwt"
+)
+
+// BodyFormat is how the token request body is encoded.
+type BodyFormat string
+
+const (
+	BodyFormatForm BodyFormat = "form"
+	BodyFormatJSON BodyFormat = "json"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// ClientCredentialsConfig describes an OAuth2 client credentials grant
+// against any provider.
+type ClientCredentialsConfig struct {
+	// TokenURL is the token endpoint. When empty it is discovered from
+	// IssuerURL's OpenID configuration.
+	TokenURL  string
+	IssuerURL string
+
+	ClientID     string
+	ClientSecret string
+	// Audience is sent as the audience parameter when set, which Auth0 and
+	// some other providers require.
+	Audience string
+	Scopes   []string
+
+	// AuthStyle defaults to AuthStyleSecretPost and BodyFormat to
+	// BodyFormatForm.
+	AuthStyle  AuthStyle
+	BodyFormat BodyFormat
+
+	// PrivateKey and KeyID are used by AuthStylePrivateKeyJWT.
+	PrivateKey crypto.Signer
+	KeyID      string
+
+	HTTPClient *http.Client
+}
+
+// NewClientCredentialsTokenSource returns a TokenSource for cfg. The token
+// endpoint is discovered on first use if cfg only names an issuer.
+func NewClientCredentialsTokenSource(cfg ClientCredentialsConfig) *TokenSource {
+	var (
+		mu       sync.Mutex
+		tokenURL = cfg.TokenURL
+	)
+	return NewTokenSource(func() (Token, error) {
+		mu.Lock()
+		if tokenURL == "" {
+			discovered, err := DiscoverTokenEndpoint(context.Background(), cfg.httpClient(), cfg.IssuerURL)
+			if err != nil {
+				mu.Unlock()
+				return Token{}, err
+			}
+			tokenURL = discovered
+		}
+		resolved := cfg
+		resolved.TokenURL = tokenURL
+		mu.Unlock()
+
+		return FetchClientCredentialsToken(context.Background(), resolved)
+	})
+}
+
+// DiscoverTokenEndpoint reads the token endpoint from the issuer's OpenID
+// configuration.
+func DiscoverTokenEndpoint(ctx context.Context, client *http.Client, issuerURL string) (string, error) {
+	if issuerURL == "" {
+		return "", fmt.Errorf("either a token url or an issuer url is required")
+	}
+
+	var discovery struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err := fetchOpenIDConfiguration(ctx, client, issuerURL, &discovery); err != nil {
+		return "", err
+	}
+	if discovery.TokenEndpoint == "" {
+		return "", fmt.Errorf("openid configuration for %s has no token_endpoint", issuerURL)
+	}
+	return discovery.TokenEndpoint, nil
+}
+
+func fetchOpenIDConfiguration(ctx context.Context, client *http.Client, issuerURL string, v any) error {
+	discoveryURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating discovery request: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching openid configuration: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from %s: %d", discoveryURL, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding openid configuration: %w", err)
+	}
+	return nil
+}
+
+// FetchClientCredentialsToken requests a new token from cfg.TokenURL.
+func FetchClientCredentialsToken(ctx context.Context, cfg ClientCredentialsConfig) (Token, error) {
+	params := map[string]string{"grant_type": "client_credentials"}
+	if cfg.Audience != "" {
+		params["audience"] = cfg.Audience
+	}
+	if len(cfg.Scopes) > 0 {
+		params["scope"] = strings.Join(cfg.Scopes, " ")
+	}
+
+	switch cfg.AuthStyle {
+	case "", AuthStyleSecretPost:
+		params["client_id"] = cfg.ClientID
+		params["client_secret"] = cfg.ClientSecret
+	case AuthStyleSecretBasic:
+	case AuthStylePrivateKeyJWT:
+		assertion, err := clientAssertion(cfg)
+		if err != nil {
+			return Token{}, err
+		}
+		params["client_id"] = cfg.ClientID
+		params["client_assertion_type"] = clientAssertionType
+		params["client_assertion"] = assertion
+	default:
+		return Token{}, fmt.Errorf("unsupported auth style %q", cfg.AuthStyle)
+	}
+
+	var (
+		body        []byte
+		contentType string
+	)
+	switch cfg.BodyFormat {
+	case "", BodyFormatForm:
+		form := url.Values{}
+		for k, v := range params {
+			form.Set(k, v)
+		}
+		body = []byte(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	case BodyFormatJSON:
+		var err error
+		if body, err = json.Marshal(params); err != nil {
+			return Token{}, fmt.Errorf("error marshaling payload: %v", err)
+		}
+		contentType = "application/json"
+	default:
+		return Token{}, fmt.Errorf("unsupported body format %q", cfg.BodyFormat)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TokenURL, bytes.NewReader(body))
+	if err != nil {
+		return Token{}, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if cfg.AuthStyle == AuthStyleSecretBasic {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	res, err := cfg.httpClient().Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Token{}, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return Token{}, fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, string(resBody))
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(resBody, &tokenResponse); err != nil {
+		return Token{}, fmt.Errorf("error unmarshaling response: %v", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return Token{}, fmt.Errorf("token response has no access_token")
+	}
+
+	lifetime := time.Duration(tokenResponse.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+
+	return Token{
+		AccessToken: tokenResponse.AccessToken,
+		ExpiresAt:   time.Now().Add(lifetime),
+	}, nil
+}
+
+func (cfg ClientCredentialsConfig) httpClient() *http.Client {
+	if cfg.HTTPClient != nil {
+		return cfg.HTTPClient
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// Settings is the flat form of ClientCredentialsConfig that the services load
+// from their secrets. Without an issuer or token URL it falls back to Auth0.
+type Settings struct {
+	Auth0Domain  string
+	IssuerURL    string
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Audience     string
+	// Scopes is a space separated list.
+	Scopes    string
+	AuthStyle string
+	// PrivateKey is a PEM encoded key for private_key_jwt.
+	PrivateKey string
+	KeyID      string
+}
+
+func (s Settings) ClientCredentials() (ClientCredentialsConfig, error) {
+	if s.IssuerURL == "" && s.TokenURL == "" {
+		cfg := Auth0Config{
+			Domain:       s.Auth0Domain,
+			ClientID:     s.ClientID,
+			ClientSecret: s.ClientSecret,
+			Audience:     s.Audience,
+		}.ClientCredentials()
+		cfg.Scopes = strings.Fields(s.Scopes)
+		return cfg, nil
+	}
+
+	cfg := ClientCredentialsConfig{
+		TokenURL:     s.TokenURL,
+		IssuerURL:    s.IssuerURL,
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		Audience:     s.Audience,
+		Scopes:       strings.Fields(s.Scopes),
+		AuthStyle:    AuthStyle(s.AuthStyle),
+		BodyFormat:   BodyFormatForm,
+		KeyID:        s.KeyID,
+	}
+	switch cfg.AuthStyle {
+	case "", AuthStyleSecretPost, AuthStyleSecretBasic:
+	case AuthStylePrivateKeyJWT:
+		key, err := ParsePrivateKeyPEM(s.PrivateKey)
+		if err != nil {
+			return ClientCredentialsConfig{}, fmt.Errorf("invalid client assertion key: %w", err)
+		}
+		cfg.PrivateKey = key
+	default:
+		return ClientCredentialsConfig{}, fmt.Errorf("unsupported auth style %q", s.AuthStyle)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var ErrUnknownKey = errors.New("no key in the jwks matches the token")
+
+// JWKS caches the signing keys published at a JSON Web Key Set URL. Keys are
+// refetched once the cache is older than MaxAge, and straight away (at most
+// once per MinRefresh) when a token names a key ID the cache doesn't know,
+// which is how a provider rotating its keys shows up.
+type JWKS struct {
+	url        string
+	httpClient *http.Client
+	// MaxAge is how long a fetched key set is used before it is refetched.
+	MaxAge time.Duration
+	// MinRefresh rate limits refetches triggered by unknown key IDs so forged
+	// tokens can't be used to hammer the provider.
+	MinRefresh time.Duration
+
+	// fetches makes concurrent refetches share one request. The fetch runs
+	// without mu held so verifying tokens with known keys never waits on it.
+	fetches singleflight.Group
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+// NewJWKS fetches the key set at url. It fails if the first fetch does, so a
+// misconfigured URL surfaces at startup.
+func NewJWKS(ctx context.Context, url string) (*JWKS, error) {
+	j := &JWKS{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		MaxAge:     time.Hour,
+		MinRefresh: time.Minute,
+	}
+	if err := j.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// DiscoverJWKSURL reads the jwks_uri from the issuer's OpenID configuration.
+func DiscoverJWKSURL(ctx context.Context, issuerURL string) (string, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := fetchOpenIDConfiguration(ctx, &http.Client{Timeout: 10 * time.Second}, issuerURL, &discovery); err != nil {
+		return "", err
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("openid configuration for %s has no jwks_uri", issuerURL)
+	}
+	return discovery.JWKSURI, nil
+}
+
+// Key returns the key with the given ID. An empty ID matches the only key in
+// a single key set.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok, stale, recentlyTried := j.lookup(kid)
+	if ok && (!stale || recentlyTried) {
+		return key, nil
+	}
+	if !ok && recentlyTried {
+		return nil, ErrUnknownKey
+	}
+
+	err := j.Refresh(ctx)
+	// Keep serving the keys we have if the refetch failed; the provider being
+	// briefly unreachable shouldn't fail every request.
+	if key, ok, _, _ := j.lookup(kid); ok {
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrUnknownKey
+}
+
+// Refresh refetches the key set, sharing a fetch already in progress.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	_, err, _ := j.fetches.Do(j.url, func() (any, error) {
+		// The fetch is shared, so one caller giving up mustn't cancel it
+		// for the rest; the client timeout bounds it instead.
+		return nil, j.fetch(context.WithoutCancel(ctx))
+	})
+	return err
+}
+
+// lookup returns the key with the given ID, whether the key set is older than
+// MaxAge and whether a fetch was attempted within MinRefresh.
+func (j *JWKS) lookup(kid string) (key crypto.PublicKey, ok, stale, recentlyTried bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key, ok = j.lookupLocked(kid)
+	return key, ok, time.Since(j.fetchedAt) > j.MaxAge, time.Since(j.lastAttempt) < j.MinRefresh
+}
+
+func (j *JWKS) lookupLocked(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// fetch downloads the key set and swaps it in. It must not be called with mu
+// held.
+func (j *JWKS) fetch(ctx context.Context) error {
+	j.mu.Lock()
+	j.lastAttempt = time.Now()
+	j.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating jwks request: %w", err)
+	}
+	res, err := j.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching jwks: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from %s: %d", j.url, res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return fmt.Errorf("error decoding jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// One key in a format we don't understand shouldn't take the
+			// others down with it.
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("jwks at %s has no usable signing keys", j.url)
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %w", err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
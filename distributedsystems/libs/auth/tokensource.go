@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// TokenSource caches an access token and refreshes it ahead of expiry.
+type TokenSource struct {
+	fetch func() (Token, error)
+	// refreshAhead is how long before expiry a token is replaced.
+	refreshAhead time.Duration
+
+	mu    sync.Mutex
+	token Token
+	// refreshAt is when the cached token should be replaced. It is worked out
+	// from the token's full lifetime when it is fetched, see refreshTime.
+	refreshAt time.Time
+}
+
+func NewTokenSource(fetch func() (Token, error)) *TokenSource {
+	return &TokenSource{
+		fetch:        fetch,
+		refreshAhead: time.Minute,
+	}
+}
+
+func NewAuth0TokenSource(config Auth0Config) *TokenSource {
+	return NewTokenSource(func() (Token, error) {
+		return FetchAuth0Token(config)
+	})
+}
+
+// Token returns the cached access token, fetching a new one if there is none
+// or it is about to expire.
+func (ts *TokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token.AccessToken != "" && time.Now().Before(ts.refreshAt) {
+		return ts.token.AccessToken, nil
+	}
+	return ts.refreshLocked()
+}
+
+// ExpiresAt returns when the cached token expires, or the zero time if no
+// token has been fetched yet.
+func (ts *TokenSource) ExpiresAt() time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.token.ExpiresAt
+}
+
+// Refresh fetches a new token even if the cached one is still valid.
+func (ts *TokenSource) Refresh() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.refreshLocked()
+}
+
+func (ts *TokenSource) refreshLocked() (string, error) {
+	token, err := ts.fetch()
+	if err != nil {
+		return "", err
+	}
+	ts.token = token
+	ts.refreshAt = ts.refreshTime(token)
+	return token.AccessToken, nil
+}
+
+// refreshTime is when a freshly fetched token should be replaced: refreshAhead
+// before it expires, or halfway through its life for short lived tokens. It
+// must only be called at fetch time, while time.Until is still the full
+// lifetime.
+func (ts *TokenSource) refreshTime(token Token) time.Time {
+	ahead := ts.refreshAhead
+	if lifetime := time.Until(token.ExpiresAt); lifetime < 2*ahead {
+		ahead = lifetime / 2
+	}
+	return token.ExpiresAt.Add(-ahead)
+}
+
+// AutoRefresh refreshes the token ahead of expiry until ctx is cancelled and
+// passes every new token to onRefresh. Failed refreshes are retried with
+// backoff while the current token is still valid.
+func (ts *TokenSource) AutoRefresh(ctx context.Context, onRefresh func(token string)) {
+	const (
+		minRetry = time.Second
+		maxRetry = 30 * time.Second
+	)
+	retry := minRetry
+
+	for {
+		ts.mu.Lock()
+		wait := time.Until(ts.refreshAt)
+		ts.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		token, err := ts.Refresh()
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to refresh token", "retry_in", retry.String(), "error", err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			retry = min(retry*2, maxRetry)
+			continue
+		}
+
+		retry = minRetry
+		slog.InfoContext(ctx, "refreshed token")
+		onRefresh(token)
+	}
+}
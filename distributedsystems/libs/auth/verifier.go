@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the claims read from a verified access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	// Scope is the space separated form used by most providers.
+	Scope string `json:"scope,omitempty"`
+	// Scp and Permissions are the list forms used by Azure AD/Okta and by
+	// Auth0's RBAC respectively.
+	Scp         []string `json:"scp,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// Scopes returns every scope granted to the token, whichever claim it came in.
+func (c *Claims) Scopes() []string {
+	scopes := strings.Fields(c.Scope)
+	scopes = append(scopes, c.Scp...)
+	return append(scopes, c.Permissions...)
+}
+
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// Verifier checks bearer tokens against a JWKS, an issuer and an audience.
+type Verifier struct {
+	jwks   *JWKS
+	parser *jwt.Parser
+}
+
+// NewVerifier returns a Verifier that only accepts tokens signed by a key in
+// jwks with the given issuer and audience. Both are required, otherwise a
+// token minted for any other API or tenant sharing the keys would be accepted.
+func NewVerifier(jwks *JWKS, issuer, audience string) (*Verifier, error) {
+	if issuer == "" {
+		return nil, fmt.Errorf("an issuer is required to verify tokens")
+	}
+	if audience == "" {
+		return nil, fmt.Errorf("an audience is required to verify tokens")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+	}
+	return &Verifier{
+		jwks:   jwks,
+		parser: jwt.NewParser(opts...),
+	}, nil
+}
+
+// Verify parses and validates a token, returning its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.jwks.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,285 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/ksuid"
+
+	"github.com/rasha-hantash/golang/distributedsystems/libs/rmqconn"
+)
+
+// AMQP is a Broker backed by RabbitMQ. amqp091 channels aren't safe for
+// concurrent use, so publishes take a channel from a bounded pool of confirm
+// mode channels and every consumer gets a channel of its own. Publishing while
+// reconnecting fails with rmqconn.ErrNotConnected; consumers wait for the
+// connection to come back.
+type AMQP struct {
+	conn       *rmqconn.Supervisor
+	publishers *rmqconn.ChannelPool
+}
+
+// NewAMQP returns a broker publishing on up to publishChannels channels at
+// once, rmqconn.DefaultPoolSize if it isn't positive.
+func NewAMQP(conn *rmqconn.Supervisor, publishChannels int) *AMQP {
+	return &AMQP{
+		conn:       conn,
+		publishers: rmqconn.NewChannelPool(conn, publishChannels),
+	}
+}
+
+// Publish is mandatory, so RabbitMQ returns the message instead of dropping it
+// when no queue is bound to the exchange.
+func (b *AMQP) Publish(ctx context.Context, exchange string, msg Message) error {
+	return b.publish(ctx, exchange, "", true, msg)
+}
+
+func (b *AMQP) Send(ctx context.Context, queue string, msg Message) error {
+	return b.publish(ctx, "", queue, false, msg)
+}
+
+// publish waits for the broker's confirm. RabbitMQ sends the basic.return for
+// an unroutable mandatory message before confirming it, so by the time the
+// confirm arrives any return is already on ch.Returns.
+func (b *AMQP) publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg Message) error {
+	ch, err := b.publishers.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.publishers.Put(ch)
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,
+		routingKey,
+		mandatory,
+		false,
+		amqp.Publishing{
+			ContentType:   msg.ContentType,
+			Headers:       msg.Headers,
+			ReplyTo:       msg.ReplyTo,
+			CorrelationId: msg.CorrelationID,
+			Body:          msg.Body,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		// Don't hand the next publisher a channel with this confirm, or a
+		// return, still to come.
+		ch.Close()
+		return fmt.Errorf("waiting for publisher confirm: %w", err)
+	}
+	select {
+	case ret := <-ch.Returns:
+		return fmt.Errorf("%w: %d %s", ErrUnroutable, ret.ReplyCode, ret.ReplyText)
+	default:
+	}
+	if !acked {
+		return ErrNacked
+	}
+	return nil
+}
+
+func (b *AMQP) ConsumeReplies(ctx context.Context, name string) (<-chan Delivery, error) {
+	ch, err := b.consumerChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ch.QueueDeclare(
+		name,  // name
+		false, // durable
+		true,  // auto delete
+		true,  // exclusive -> important to ensure that no one else can recieve responses from this queue
+		false,
+		nil,
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare a queue: %w", err)
+	}
+
+	consumerTag := fmt.Sprintf("%s-%s", name, ksuid.New().String())
+	msgs, err := ch.Consume(
+		name,
+		consumerTag,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to register a consumer: %w", err)
+	}
+	return forward(ctx, ch, consumerTag, msgs, true), nil
+}
+
+// Subscribe binds a fresh exclusive queue to exchange. The queue goes away
+// with its consumer, so transactions published while disconnected are not
+// delivered; call Subscribe again once the returned channel closes.
+func (b *AMQP) Subscribe(ctx context.Context, exchange, consumer string, prefetch int) (<-chan Delivery, error) {
+	ch, err := b.consumerChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	q, err := ch.QueueDeclare(
+		"",
+		false,
+		true,
+		true,
+		false,
+		nil,
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare a queue: %w", err)
+	}
+
+	err = ch.QueueBind(
+		q.Name,
+		"",
+		exchange,
+		false,
+		nil,
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to bind a queue: %w", err)
+	}
+
+	consumerTag := fmt.Sprintf("%s-%s", consumer, ksuid.New().String())
+	msgs, err := ch.Consume(
+		q.Name,
+		consumerTag,
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to register a consumer: %w", err)
+	}
+	return forward(ctx, ch, consumerTag, msgs, false), nil
+}
+
+// consumerChannel opens a channel for one consumer, waiting for the
+// connection while reconnecting. forward closes it when the consumer stops.
+func (b *AMQP) consumerChannel(ctx context.Context) (*amqp.Channel, error) {
+	conn, err := b.conn.WaitConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a channel: %w", err)
+	}
+	return ch, nil
+}
+
+func (b *AMQP) Close() error {
+	b.publishers.Close()
+	return b.conn.Close()
+}
+
+// forward hands deliveries out one at a time until msgs closes or ctx is done,
+// in which case it cancels the consumer, and then closes the consumer's
+// channel once every delivery handed out has been acked or nacked.
+func forward(ctx context.Context, ch *amqp.Channel, consumerTag string, msgs <-chan amqp.Delivery, autoAck bool) <-chan Delivery {
+	out := make(chan Delivery)
+	go func() {
+		// unacked tracks deliveries still being processed, whose acks need the
+		// channel to stay open.
+		var unacked sync.WaitGroup
+		defer ch.Close()
+		defer unacked.Wait()
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				cancelConsumer(ctx, ch, consumerTag, msgs, autoAck)
+				return
+			case d, ok := <-msgs:
+				if !ok {
+					return
+				}
+				delivery := newDelivery(d, autoAck, &unacked)
+				select {
+				case out <- delivery:
+				case <-ctx.Done():
+					delivery.Nack(true)
+					cancelConsumer(ctx, ch, consumerTag, msgs, autoAck)
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// cancelConsumer cancels the consumer and requeues the deliveries that were
+// already sent to it but not handed out. Any the client library drops on
+// cancel stay unacked and are requeued by the broker when the channel closes.
+func cancelConsumer(ctx context.Context, ch *amqp.Channel, consumerTag string, msgs <-chan amqp.Delivery, autoAck bool) {
+	if err := ch.Cancel(consumerTag, false); err != nil {
+		// The channel is gone, and with it every unacked delivery.
+		slog.WarnContext(ctx, "failed to cancel consumer", "consumer_tag", consumerTag, "error", err.Error())
+		return
+	}
+
+	requeued := 0
+	for d := range msgs {
+		if !autoAck {
+			d.Nack(false, true)
+			requeued++
+		}
+	}
+	if !autoAck {
+		slog.InfoContext(ctx, "cancelled consumer", "consumer_tag", consumerTag, "requeued", requeued)
+	}
+}
+
+// newDelivery adds a delivery that must be acked to unacked until it is acked
+// or nacked.
+func newDelivery(d amqp.Delivery, autoAck bool, unacked *sync.WaitGroup) Delivery {
+	delivery := Delivery{
+		Message: Message{
+			ContentType:   d.ContentType,
+			Headers:       d.Headers,
+			ReplyTo:       d.ReplyTo,
+			CorrelationID: d.CorrelationId,
+			Body:          d.Body,
+		},
+		RoutingKey: d.RoutingKey,
+	}
+	if !autoAck {
+		unacked.Add(1)
+		var once sync.Once
+		settled := unacked.Done
+		delivery.ack = func() error {
+			defer once.Do(settled)
+			return d.Ack(false)
+		}
+		delivery.nack = func(requeue bool) error {
+			defer once.Do(settled)
+			return d.Nack(false, requeue)
+		}
+	}
+	return delivery
+}
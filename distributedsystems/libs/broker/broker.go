@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"context"
+	"errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrUnroutable is returned by Publish when no queue is bound to the
+	// exchange, e.g. because no operator is subscribed.
+	ErrUnroutable = errors.New("no queue is bound to the exchange")
+	// ErrNacked is returned when the broker refuses to take a message.
+	ErrNacked = errors.New("broker did not accept the message")
+)
+
+// Broker is the messaging the dispatcher and operators need: fanning
+// transactions out to every operator and sending votes back to the reply
+// queue of the dispatcher that broadcast them. AMQP talks to RabbitMQ and
+// Memory runs in process for tests.
+type Broker interface {
+	// Publish fans msg out to every queue bound to exchange and returns once
+	// the broker has taken it. It fails with ErrUnroutable when no queue is
+	// bound and ErrNacked when the broker refuses it.
+	Publish(ctx context.Context, exchange string, msg Message) error
+	// Send delivers msg to the named queue and returns once the broker has
+	// taken it. Messages for a queue that doesn't exist are dropped.
+	Send(ctx context.Context, queue string, msg Message) error
+	// ConsumeReplies declares a non-durable queue called name that only this
+	// client consumes from, and delivers its messages, acknowledged on
+	// receipt, until ctx is done or the connection drops. The queue goes away
+	// with the connection; call ConsumeReplies again once the returned
+	// channel closes.
+	ConsumeReplies(ctx context.Context, name string) (<-chan Delivery, error)
+	// Subscribe binds a new queue to exchange and delivers its messages, which
+	// must be acked, until ctx is done or the connection drops. consumer names
+	// the subscriber in the broker and prefetch caps how many deliveries can
+	// be unacked at once, 0 for no limit. When ctx is done, deliveries not
+	// yet handed out are requeued.
+	//
+	// The channel can be read from several goroutines to process deliveries
+	// concurrently; each delivery is acked on its own.
+	Subscribe(ctx context.Context, exchange, consumer string, prefetch int) (<-chan Delivery, error)
+	Close() error
+}
+
+type Message struct {
+	ContentType string
+	// Headers carry the request ID and trace context, see the correlation
+	// package.
+	Headers amqp.Table
+	// ReplyTo is the queue to send replies to and CorrelationID ties a reply
+	// to the message it answers.
+	ReplyTo       string
+	CorrelationID string
+	Body          []byte
+}
+
+// Delivery is a message received from a queue.
+type Delivery struct {
+	Message
+	// RoutingKey is the queue name for messages sent with Send and empty for
+	// messages published to a fanout exchange.
+	RoutingKey string
+
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+// Ack acknowledges a delivery from Subscribe. It is a no-op for deliveries
+// from ConsumeReplies.
+func (d Delivery) Ack() error {
+	if d.ack == nil {
+		return nil
+	}
+	return d.ack()
+}
+
+// Nack rejects a delivery from Subscribe, putting it back on the queue when
+// requeue is true. It is a no-op for deliveries from ConsumeReplies.
+func (d Delivery) Nack(requeue bool) error {
+	if d.nack == nil {
+		return nil
+	}
+	return d.nack(requeue)
+}
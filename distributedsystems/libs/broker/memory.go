@@ -0,0 +1,226 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/segmentio/ksuid"
+)
+
+// memoryQueueSize is how many messages an in-memory queue holds before
+// publishing to it fails with ErrNacked.
+const memoryQueueSize = 1024
+
+// Memory is an in-process Broker for tests. Exchanges are fanouts that exist
+// once something subscribes to them and every queue has a single consumer.
+type Memory struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+	// bindings maps an exchange to the names of the queues bound to it.
+	bindings map[string]map[string]bool
+	// onPublish is called after every successful Publish.
+	onPublish func(exchange string, msg Message)
+}
+
+type memoryQueue struct {
+	name     string
+	msgs     chan Delivery
+	deleted  chan struct{}
+	consumed bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		queues:   make(map[string]*memoryQueue),
+		bindings: make(map[string]map[string]bool),
+	}
+}
+
+func (b *Memory) Publish(ctx context.Context, exchange string, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.bindings[exchange]) == 0 {
+		return ErrUnroutable
+	}
+	// Check every queue has room first so the message reaches all of them or
+	// none, like a publish RabbitMQ nacks. Pushes only happen with b.mu held,
+	// so the queues can't fill up in between.
+	for name := range b.bindings[exchange] {
+		if q := b.queues[name]; q.full() {
+			return fmt.Errorf("%w: queue %s is full", ErrNacked, q.name)
+		}
+	}
+	for name := range b.bindings[exchange] {
+		if err := b.queues[name].push(Delivery{Message: msg}); err != nil {
+			return err
+		}
+	}
+	if b.onPublish != nil {
+		b.onPublish(exchange, msg)
+	}
+	return nil
+}
+
+func (b *Memory) Send(ctx context.Context, queue string, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil
+	}
+	return q.push(Delivery{Message: msg, RoutingKey: queue})
+}
+
+func (b *Memory) declare(name string) *memoryQueue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{
+			name:    name,
+			msgs:    make(chan Delivery, memoryQueueSize),
+			deleted: make(chan struct{}),
+		}
+		b.queues[name] = q
+	}
+	return q
+}
+
+// deleteQueue removes q, unless it was already replaced by a queue declared
+// with the same name.
+func (b *Memory) deleteQueue(q *memoryQueue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.queues[q.name] != q {
+		return
+	}
+	delete(b.queues, q.name)
+	for _, queues := range b.bindings {
+		delete(queues, q.name)
+	}
+	close(q.deleted)
+}
+
+func (b *Memory) ConsumeReplies(ctx context.Context, name string) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if q, ok := b.queues[name]; ok && q.consumed {
+		return nil, fmt.Errorf("queue %s already has a consumer", name)
+	}
+	q := b.declare(name)
+	q.consumed = true
+	return b.forward(ctx, q, false), nil
+}
+
+// Subscribe ignores prefetch; deliveries are handed out as fast as they are
+// read.
+func (b *Memory) Subscribe(ctx context.Context, exchange, consumer string, prefetch int) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.declare(fmt.Sprintf("%s-%s", consumer, ksuid.New().String()))
+	q.consumed = true
+	if b.bindings[exchange] == nil {
+		b.bindings[exchange] = make(map[string]bool)
+	}
+	b.bindings[exchange][q.name] = true
+	return b.forward(ctx, q, true), nil
+}
+
+// Subscribers returns how many queues are bound to exchange, so tests can
+// wait for consumers to be ready before publishing.
+func (b *Memory) Subscribers(exchange string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.bindings[exchange])
+}
+
+// OnPublish calls f after every message published to an exchange has reached
+// its queues, so tests can act once a broadcast is out. f runs with the
+// broker locked and must not call it.
+func (b *Memory) OnPublish(f func(exchange string, msg Message)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.onPublish = f
+}
+
+// Close deletes every queue, which closes every consumer's channel.
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	queues := make([]*memoryQueue, 0, len(b.queues))
+	for _, q := range b.queues {
+		queues = append(queues, q)
+	}
+	b.mu.Unlock()
+
+	for _, q := range queues {
+		b.deleteQueue(q)
+	}
+	return nil
+}
+
+// forward hands deliveries out until ctx is done or the queue is deleted, and
+// then deletes the queue like an exclusive queue going away with its
+// connection.
+func (b *Memory) forward(ctx context.Context, q *memoryQueue, ack bool) <-chan Delivery {
+	out := make(chan Delivery)
+	go func() {
+		defer close(out)
+		defer b.deleteQueue(q)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.deleted:
+				return
+			case d := <-q.msgs:
+				if ack {
+					d.ack = func() error { return nil }
+					d.nack = func(requeue bool) error {
+						if !requeue {
+							return nil
+						}
+						b.mu.Lock()
+						defer b.mu.Unlock()
+						return q.push(d)
+					}
+				}
+				select {
+				case out <- d:
+				case <-ctx.Done():
+					return
+				case <-q.deleted:
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// full must be called with b.mu held.
+func (q *memoryQueue) full() bool {
+	return len(q.msgs) == cap(q.msgs)
+}
+
+// push must be called with b.mu held. Messages for a deleted queue are
+// dropped.
+func (q *memoryQueue) push(d Delivery) error {
+	select {
+	case <-q.deleted:
+		return nil
+	default:
+	}
+
+	select {
+	case q.msgs <- d:
+		return nil
+	default:
+		return fmt.Errorf("%w: queue %s is full", ErrNacked, q.name)
+	}
+}
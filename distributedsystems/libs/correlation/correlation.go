@@ -0,0 +1,102 @@
+package correlation
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/rasha-hantash/golang/distributedsystems/libs/logger"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/tracing"
+)
+
+const (
+	HeaderRequestID = "X-Request-ID"
+
+	// maxRequestIDLength bounds request IDs supplied by callers so they can't
+	// bloat every log line.
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// NewContext stores the request ID in ctx and adds it to every log line
+// written with it.
+func NewContext(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+	return logger.AppendCtx(ctx, slog.String("request_id", requestID))
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Middleware accepts the caller's X-Request-ID, or generates one, stores it in
+// the request context and echoes it on the response. The W3C traceparent is
+// handled by the tracing middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(HeaderRequestID)
+		if !validRequestID(requestID) {
+			requestID = NewRequestID()
+		}
+
+		w.Header().Set(HeaderRequestID, requestID)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), requestID)))
+	})
+}
+
+// InjectHTTP sets the request ID and the current span's traceparent on an
+// outgoing HTTP request.
+func InjectHTTP(ctx context.Context, h http.Header) {
+	if id := RequestID(ctx); id != "" {
+		h.Set(HeaderRequestID, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Inject adds the request ID and the current span's traceparent to AMQP
+// message headers, creating the table if needed.
+func Inject(ctx context.Context, headers amqp.Table) amqp.Table {
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	if id := RequestID(ctx); id != "" {
+		headers[HeaderRequestID] = id
+	}
+	otel.GetTextMapPropagator().Inject(ctx, tracing.HeaderCarrier(headers))
+	return headers
+}
+
+// Extract reads the request ID and trace context from AMQP message headers
+// into ctx. Messages without a request ID, e.g. from an older dispatcher, get
+// a fresh one so their logs can still be tied together.
+func Extract(ctx context.Context, headers amqp.Table) context.Context {
+	requestID, _ := headers[HeaderRequestID].(string)
+	if !validRequestID(requestID) {
+		requestID = NewRequestID()
+	}
+	ctx = otel.GetTextMapPropagator().Extract(ctx, tracing.HeaderCarrier(headers))
+	return NewContext(ctx, requestID)
+}
+
+func NewRequestID() string {
+	return "req_" + ksuid.New().String()
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,8 @@ package logger
 import (
 	"context"
 	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ctxKey string
@@ -15,10 +17,8 @@ type ContextHandler struct {
 	slog.Handler
 }
 
-// todo look into adding this trace 
-
-
-// Handle adds contextual attributes to the Record before calling the underlying handler
+// Handle adds contextual attributes, and the trace and span ID of the active
+// span, to the Record before calling the underlying handler
 func (h ContextHandler) Handle(ctx context.Context, r slog.Record) error {
 	if attrs, ok := ctx.Value(slogFields).([]slog.Attr); ok {
 		for _, v := range attrs {
@@ -26,6 +26,13 @@ func (h ContextHandler) Handle(ctx context.Context, r slog.Record) error {
 		}
 	}
 
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+
 	return h.Handler.Handle(ctx, r)
 }
 
@@ -0,0 +1,150 @@
+package rmqconn
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultPoolSize is the number of channels a ChannelPool opens when no size
+// is given.
+const DefaultPoolSize = 8
+
+var (
+	poolInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rabbitmq_channel_pool_in_use",
+		Help: "Pooled RabbitMQ channels currently handed out.",
+	})
+	poolWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rabbitmq_channel_pool_wait_seconds",
+		Help:    "Time spent waiting for a pooled RabbitMQ channel.",
+		Buckets: []float64{0.0001, 0.001, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	})
+)
+
+// returnsBuffer holds basic.returns until the publisher reads them. A pooled
+// channel has one publish in flight at a time, so it only ever holds one.
+const returnsBuffer = 1
+
+// ChannelPool hands out channels on the supervisor's connection so concurrent
+// publishers don't share one. At most size channels are in use at once; Get
+// blocks when all of them are, which pushes back on callers instead of
+// opening channels without bound. Closed channels, e.g. after a channel error
+// or a reconnect, are discarded and replaced on demand.
+//
+// Channels are in confirm mode, so publishes can wait for the broker to
+// accept them, and unroutable mandatory publishes arrive on Returns.
+type ChannelPool struct {
+	conn  *Supervisor
+	slots chan struct{}
+
+	mu   sync.Mutex
+	idle []*Channel
+}
+
+// Channel is a pooled channel. A publisher that gives up waiting for its
+// confirm should close the channel rather than leave a late confirm or return
+// for the next one.
+type Channel struct {
+	*amqp.Channel
+	// Returns receives messages published as mandatory that no queue was
+	// bound for, before their confirm.
+	Returns <-chan amqp.Return
+}
+
+// NewChannelPool returns a pool of up to size channels, DefaultPoolSize if size
+// isn't positive.
+func NewChannelPool(conn *Supervisor, size int) *ChannelPool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	return &ChannelPool{
+		conn:  conn,
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Get returns an open channel, waiting until one is free or ctx is done. It
+// must be handed back with Put.
+func (p *ChannelPool) Get(ctx context.Context) (*Channel, error) {
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		poolWait.Observe(time.Since(start).Seconds())
+		return nil, ctx.Err()
+	}
+	poolWait.Observe(time.Since(start).Seconds())
+
+	if ch := p.popIdle(); ch != nil {
+		poolInUse.Inc()
+		return ch, nil
+	}
+
+	ch, err := p.open()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	poolInUse.Inc()
+	return ch, nil
+}
+
+// Put returns a channel to the pool. Closed channels are dropped.
+func (p *ChannelPool) Put(ch *Channel) {
+	if !ch.IsClosed() {
+		p.mu.Lock()
+		p.idle = append(p.idle, ch)
+		p.mu.Unlock()
+	}
+	poolInUse.Dec()
+	<-p.slots
+}
+
+// Close closes the idle channels. Channels still in use are closed with the
+// connection.
+func (p *ChannelPool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, ch := range idle {
+		ch.Close()
+	}
+}
+
+func (p *ChannelPool) popIdle() *Channel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.idle) > 0 {
+		ch := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !ch.IsClosed() {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (p *ChannelPool) open() (*Channel, error) {
+	conn, err := p.conn.Connection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, returnsBuffer))
+	return &Channel{Channel: ch, Returns: returns}, nil
+}
@@ -0,0 +1,338 @@
+package rmqconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrNotConnected = errors.New("not connected to rabbitmq")
+
+// ErrAuth, ErrDial and ErrTopology classify why connecting failed. Match them
+// with errors.Is on the error returned by New.
+var (
+	ErrAuth     = errors.New("rabbitmq authentication failed")
+	ErrDial     = errors.New("failed to connect to rabbitmq")
+	ErrTopology = errors.New("failed to set up rabbitmq topology")
+)
+
+// ConnectError is returned when connecting to RabbitMQ fails.
+type ConnectError struct {
+	// Kind is ErrAuth, ErrDial or ErrTopology.
+	Kind error
+	Err  error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *ConnectError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// AuthFailed marks an error returned by Config.Dial, such as failing to get a
+// token, as an authentication failure.
+func AuthFailed(err error) error {
+	return &ConnectError{Kind: ErrAuth, Err: err}
+}
+
+var (
+	connectedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rabbitmq_connected",
+		Help: "1 while connected to RabbitMQ, 0 while reconnecting.",
+	})
+	reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rabbitmq_reconnects_total",
+		Help: "Successful reconnects to RabbitMQ after losing the connection.",
+	})
+	reconnectFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rabbitmq_reconnect_failures_total",
+		Help: "Failed attempts to reconnect to RabbitMQ.",
+	})
+)
+
+type Config struct {
+	// Dial opens a new connection. It is called for the initial connection
+	// and for every reconnect, so it should fetch fresh credentials.
+	Dial func() (*amqp.Connection, error)
+	// Setup declares the exchanges, queues and bindings the service needs. It
+	// runs on the new channel after every (re)connect.
+	Setup func(ch *amqp.Channel) error
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// reconnect attempts. They default to 500ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Supervisor owns a RabbitMQ connection and channel. It watches both for
+// closure and reconnects with exponential backoff, re-running Setup, until
+// the context passed to New is cancelled or Close is called.
+type Supervisor struct {
+	cfg Config
+
+	mu    sync.RWMutex
+	conn  *amqp.Connection
+	ch    *amqp.Channel
+	ready chan struct{} // closed while connected
+	// lastErr is why the latest reconnect attempt failed, nil while connected.
+	lastErr error
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New connects and starts supervising the connection. The initial connection
+// is not retried so misconfiguration surfaces straight away.
+func New(ctx context.Context, cfg Config) (*Supervisor, error) {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+
+	s := &Supervisor{
+		cfg:    cfg,
+		ready:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+
+	conn, ch, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	s.setConnected(conn, ch)
+
+	go s.supervise(ctx, conn, ch)
+	return s, nil
+}
+
+// Channel returns the current channel, or ErrNotConnected while reconnecting.
+func (s *Supervisor) Channel() (*amqp.Channel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.ch == nil {
+		return nil, ErrNotConnected
+	}
+	return s.ch, nil
+}
+
+// Connection returns the current connection, or ErrNotConnected while
+// reconnecting.
+func (s *Supervisor) Connection() (*amqp.Connection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return s.conn, nil
+}
+
+// WaitConnection blocks until connected or ctx is done.
+func (s *Supervisor) WaitConnection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		s.mu.RLock()
+		conn, ready := s.conn, s.ready
+		s.mu.RUnlock()
+
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-ready:
+		case <-s.closed:
+			return nil, ErrNotConnected
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// LastError returns why the latest reconnect attempt failed, or nil while
+// connected. It is a *ConnectError.
+func (s *Supervisor) LastError() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastErr
+}
+
+// UpdateSecret passes a new secret, such as a refreshed OAuth2 token, to the
+// broker on the live connection so it isn't closed when the old one expires.
+func (s *Supervisor) UpdateSecret(secret, reason string) error {
+	conn, err := s.Connection()
+	if err != nil {
+		return err
+	}
+	return conn.UpdateSecret(secret, reason)
+}
+
+// Close stops supervising and closes the channel and connection.
+func (s *Supervisor) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		s.mu.Lock()
+		conn, ch := s.conn, s.ch
+		s.conn, s.ch = nil, nil
+		s.mu.Unlock()
+		connectedGauge.Set(0)
+
+		if ch != nil {
+			ch.Close()
+		}
+		if conn != nil {
+			err = conn.Close()
+		}
+	})
+	return err
+}
+
+func (s *Supervisor) connect() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := s.cfg.Dial()
+	if err != nil {
+		return nil, nil, dialError(err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, &ConnectError{Kind: ErrDial, Err: fmt.Errorf("failed to open a channel: %w", err)}
+	}
+
+	if s.cfg.Setup != nil {
+		if err := s.cfg.Setup(ch); err != nil {
+			conn.Close()
+			return nil, nil, &ConnectError{Kind: ErrTopology, Err: err}
+		}
+	}
+
+	return conn, ch, nil
+}
+
+// dialError classifies an error from Config.Dial. The broker refusing the
+// credentials is an authentication failure like failing to get them.
+func dialError(err error) error {
+	var connectErr *ConnectError
+	if errors.As(err, &connectErr) {
+		return err
+	}
+
+	var amqpErr *amqp.Error
+	if errors.Is(err, amqp.ErrSASL) || errors.Is(err, amqp.ErrCredentials) ||
+		(errors.As(err, &amqpErr) && amqpErr.Code == amqp.AccessRefused) {
+		return &ConnectError{Kind: ErrAuth, Err: err}
+	}
+	return &ConnectError{Kind: ErrDial, Err: err}
+}
+
+// setConnected publishes a new connection. It reports false, leaving the
+// connection for the caller to close, if the supervisor was closed meanwhile.
+func (s *Supervisor) setConnected(conn *amqp.Connection, ch *amqp.Channel) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+
+	s.conn, s.ch = conn, ch
+	s.lastErr = nil
+	close(s.ready)
+	connectedGauge.Set(1)
+	return true
+}
+
+func (s *Supervisor) setDisconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn, s.ch = nil, nil
+	s.ready = make(chan struct{})
+	connectedGauge.Set(0)
+}
+
+func (s *Supervisor) supervise(ctx context.Context, conn *amqp.Connection, ch *amqp.Channel) {
+	for {
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+		var reason *amqp.Error
+		select {
+		case <-s.closed:
+			return
+		case <-ctx.Done():
+			s.Close()
+			return
+		case reason = <-connClosed:
+		case reason = <-chClosed:
+		}
+
+		s.setDisconnected()
+		// A channel can close on its own after a channel level error; drop
+		// the whole connection so everything is rebuilt from scratch.
+		conn.Close()
+
+		attrs := []any{}
+		if reason != nil {
+			attrs = append(attrs, "reason", reason.Error())
+		}
+		slog.WarnContext(ctx, "lost connection to rabbitmq, reconnecting", attrs...)
+
+		var ok bool
+		conn, ch, ok = s.reconnect(ctx)
+		if !ok {
+			return
+		}
+		if !s.setConnected(conn, ch) {
+			conn.Close()
+			return
+		}
+		reconnects.Inc()
+		slog.InfoContext(ctx, "reconnected to rabbitmq")
+	}
+}
+
+func (s *Supervisor) reconnect(ctx context.Context) (*amqp.Connection, *amqp.Channel, bool) {
+	backoff := s.cfg.MinBackoff
+	for attempt := 1; ; attempt++ {
+		// Jitter keeps a fleet of services from reconnecting in lockstep after
+		// a broker restart.
+		wait := time.Duration(rand.Int63n(int64(backoff))) + backoff/2
+		select {
+		case <-s.closed:
+			return nil, nil, false
+		case <-ctx.Done():
+			s.Close()
+			return nil, nil, false
+		case <-time.After(wait):
+		}
+
+		conn, ch, err := s.connect()
+		if err == nil {
+			return conn, ch, true
+		}
+		reconnectFailures.Inc()
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+		slog.WarnContext(ctx, "failed to reconnect to rabbitmq", "attempt", attempt, "error", err.Error())
+
+		backoff = min(backoff*2, s.cfg.MaxBackoff)
+	}
+}
@@ -0,0 +1,87 @@
+package vote
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Vote is an operator's verdict on a transaction, as signed.
+type Vote struct {
+	OperatorID    string
+	TransactionID string
+	IsValid       bool
+	// Reason is the compliance reason code and ListVersion the deny list
+	// version behind the verdict. They are signed because they end up in the
+	// ledger for auditors.
+	Reason      string
+	ListVersion string
+}
+
+// Payload returns the bytes an operator signs for its vote. The operator ID is
+// part of the payload so one operator's signature can't be replayed as another
+// operator's vote, and strings are quoted so no field can spill into the next.
+func Payload(v Vote) []byte {
+	return []byte(strings.Join([]string{
+		strconv.Quote(v.OperatorID),
+		strconv.Quote(v.TransactionID),
+		strconv.FormatBool(v.IsValid),
+		strconv.Quote(v.Reason),
+		strconv.Quote(v.ListVersion),
+	}, "|"))
+}
+
+// Sign signs an operator's vote.
+func Sign(key ed25519.PrivateKey, v Vote) []byte {
+	return ed25519.Sign(key, Payload(v))
+}
+
+// Verify reports whether signature is a valid signature of the vote by the
+// holder of key.
+func Verify(key ed25519.PublicKey, v Vote, signature []byte) bool {
+	return ed25519.Verify(key, Payload(v), signature)
+}
+
+// ParsePrivateKey decodes a base64 encoded ed25519 seed or private key.
+func ParsePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding signing key: %w", err)
+	}
+
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	default:
+		return nil, fmt.Errorf("signing key must be %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(b))
+	}
+}
+
+// ParsePublicKey decodes a base64 encoded ed25519 public key.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// ParsePublicKeys decodes a map of operator ID to base64 encoded public key.
+func ParsePublicKeys(encoded map[string]string) (map[string]ed25519.PublicKey, error) {
+	keys := make(map[string]ed25519.PublicKey, len(encoded))
+	for operatorID, k := range encoded {
+		key, err := ParsePublicKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("operator %s: %w", operatorID, err)
+		}
+		keys[operatorID] = key
+	}
+	return keys, nil
+}
@@ -0,0 +1,22 @@
+package compliance
+
+import (
+	"context"
+	"regexp"
+)
+
+var addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// AddressFormat rejects transactions whose from or to address is not a
+// 0x-prefixed 40 character hex string.
+type AddressFormat struct{}
+
+func (AddressFormat) Validate(ctx context.Context, txn Transaction) Result {
+	if !addressPattern.MatchString(txn.From) {
+		return Deny(ReasonInvalidAddress, "from address %q is malformed", txn.From)
+	}
+	if !addressPattern.MatchString(txn.To) {
+		return Deny(ReasonInvalidAddress, "to address %q is malformed", txn.To)
+	}
+	return Allow()
+}
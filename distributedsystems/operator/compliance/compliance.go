@@ -0,0 +1,118 @@
+package compliance
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ReasonCode explains why a transaction was accepted or rejected.
+type ReasonCode string
+
+const (
+	ReasonOK               ReasonCode = "ok"
+	ReasonInvalidAddress   ReasonCode = "invalid_address"
+	ReasonDeniedAddress    ReasonCode = "denied_address"
+	ReasonValueExceeded    ReasonCode = "value_exceeded"
+	ReasonVelocityExceeded ReasonCode = "velocity_exceeded"
+)
+
+// Transaction is the part of a transaction request the rules look at.
+type Transaction struct {
+	TxnHash string
+	From    string
+	To      string
+	Value   int64
+}
+
+// Result is a validator's verdict on a transaction.
+type Result struct {
+	Valid  bool
+	Reason ReasonCode
+	Detail string
+	// ListVersion is the version of the deny list the verdict was made
+	// against, if one was consulted.
+	ListVersion string
+}
+
+func Allow() Result {
+	return Result{Valid: true, Reason: ReasonOK}
+}
+
+func Deny(reason ReasonCode, format string, args ...interface{}) Result {
+	return Result{Valid: false, Reason: reason, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Validator decides whether a transaction is compliant. Every rule is a
+// Validator, and rules are combined with Chain.
+type Validator interface {
+	Validate(ctx context.Context, txn Transaction) Result
+}
+
+// Chain runs validators in order and returns the first rejection. A
+// transaction that passes every validator is allowed.
+type Chain []Validator
+
+func (c Chain) Validate(ctx context.Context, txn Transaction) Result {
+	var listVersion string
+	for _, v := range c {
+		res := v.Validate(ctx, txn)
+		if res.ListVersion != "" {
+			listVersion = res.ListVersion
+		}
+		if !res.Valid {
+			res.ListVersion = listVersion
+			return res
+		}
+	}
+
+	res := Allow()
+	res.ListVersion = listVersion
+	return res
+}
+
+// Config selects the rules an operator enforces. Zero values disable a rule,
+// except the address format check which always runs.
+type Config struct {
+	// DenyListSource is a file path or http(s) URL. It is reloaded every
+	// DenyListReloadInterval when that is set.
+	DenyListSource         string
+	DenyListReloadInterval time.Duration
+	MaxValue               int64
+	VelocityLimit          int
+	VelocityWindow         time.Duration
+}
+
+// New builds the validator chain described by cfg. A reloadable deny list is
+// watched until ctx is cancelled.
+func New(ctx context.Context, cfg Config) (Chain, error) {
+	chain := Chain{AddressFormat{}}
+
+	if cfg.DenyListSource != "" {
+		denyList, err := LoadDenyList(ctx, cfg.DenyListSource)
+		if err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "loaded deny list", "source", cfg.DenyListSource, "version", denyList.Version(), "addresses", denyList.Len())
+		if cfg.DenyListReloadInterval > 0 {
+			go denyList.Watch(ctx, cfg.DenyListReloadInterval)
+		}
+		chain = append(chain, denyList)
+	}
+
+	if cfg.MaxValue > 0 {
+		chain = append(chain, ValueThreshold{Max: cfg.MaxValue})
+	}
+
+	// Velocity goes last so only transactions that pass every other rule
+	// count towards the sender's limit.
+	if cfg.VelocityLimit > 0 {
+		if cfg.VelocityWindow <= 0 {
+			return nil, fmt.Errorf("velocity window must be positive when a velocity limit is set")
+		}
+		chain = append(chain, NewVelocity(cfg.VelocityLimit, cfg.VelocityWindow))
+	}
+
+	return chain, nil
+}
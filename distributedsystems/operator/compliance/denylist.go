@@ -0,0 +1,252 @@
+package compliance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DenyList rejects transactions to or from a sanctioned address. The list is
+// loaded from a local file or an http(s) URL and can be reloaded while the
+// operator is running; every reload atomically swaps in the new set.
+type DenyList struct {
+	source     string
+	httpClient *http.Client
+	current    atomic.Pointer[denySet]
+
+	// Only touched by Reload, which is not safe for concurrent use.
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// loadedList is the raw content of the list.
+type loadedList struct {
+	data   []byte
+	isJSON bool
+	// commit records the file's mod time and size, or the response's ETag,
+	// so later reloads can skip an unchanged list. It is only called once the
+	// content parsed, so a bad list keeps being reported until it's fixed.
+	commit func()
+}
+
+type denySet struct {
+	addresses map[string]struct{}
+	version   string
+}
+
+// LoadDenyList loads a deny list from source, which is either a path or an
+// http(s) URL. The content is a JSON array of addresses, or a CSV with one
+// address in the first column of each row. JSON is detected from a .json
+// extension or a JSON content type.
+func LoadDenyList(ctx context.Context, source string) (*DenyList, error) {
+	d := &DenyList{
+		source:     source,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	if _, err := d.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewDenyList builds a static deny list from addresses.
+func NewDenyList(addresses []string) *DenyList {
+	d := &DenyList{}
+	d.current.Store(newDenySet(addresses, versionOf([]byte(strings.Join(addresses, "\n")))))
+	return d
+}
+
+func newDenySet(addresses []string, version string) *denySet {
+	set := make(map[string]struct{}, len(addresses))
+	for _, a := range addresses {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			set[a] = struct{}{}
+		}
+	}
+	return &denySet{addresses: set, version: version}
+}
+
+func (d *DenyList) Validate(ctx context.Context, txn Transaction) Result {
+	set := d.current.Load()
+	if _, ok := set.addresses[strings.ToLower(txn.From)]; ok {
+		res := Deny(ReasonDeniedAddress, "from address %s is on the deny list", txn.From)
+		res.ListVersion = set.version
+		return res
+	}
+	if _, ok := set.addresses[strings.ToLower(txn.To)]; ok {
+		res := Deny(ReasonDeniedAddress, "to address %s is on the deny list", txn.To)
+		res.ListVersion = set.version
+		return res
+	}
+	res := Allow()
+	res.ListVersion = set.version
+	return res
+}
+
+// Version identifies the currently loaded list by a hash of its content.
+func (d *DenyList) Version() string {
+	return d.current.Load().version
+}
+
+func (d *DenyList) Len() int {
+	return len(d.current.Load().addresses)
+}
+
+// Reload fetches the list again and swaps it in if its content changed. On
+// error the current list stays in place.
+func (d *DenyList) Reload(ctx context.Context) (bool, error) {
+	var (
+		list *loadedList
+		err  error
+	)
+	if strings.HasPrefix(d.source, "http://") || strings.HasPrefix(d.source, "https://") {
+		list, err = d.fetch(ctx)
+	} else {
+		list, err = d.read()
+	}
+	if err != nil {
+		return false, fmt.Errorf("error loading deny list %s: %w", d.source, err)
+	}
+	if list == nil {
+		return false, nil
+	}
+
+	version := versionOf(list.data)
+	if current := d.current.Load(); current != nil && current.version == version {
+		list.commit()
+		return false, nil
+	}
+
+	addresses, err := parseAddresses(list.data, list.isJSON)
+	if err != nil {
+		return false, fmt.Errorf("error parsing deny list %s: %w", d.source, err)
+	}
+	d.current.Store(newDenySet(addresses, version))
+	list.commit()
+	return true, nil
+}
+
+// Watch reloads the list every interval until ctx is cancelled.
+func (d *DenyList) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := d.Reload(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to reload deny list", "source", d.source, "version", d.Version(), "error", err.Error())
+				continue
+			}
+			if changed {
+				slog.InfoContext(ctx, "reloaded deny list", "source", d.source, "version", d.Version(), "addresses", d.Len())
+			}
+		}
+	}
+}
+
+// read returns the file's content, or nil if it hasn't changed since the last
+// list loaded.
+func (d *DenyList) read() (*loadedList, error) {
+	info, err := os.Stat(d.source)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(d.source)
+	if err != nil {
+		return nil, err
+	}
+	return &loadedList{
+		data:   data,
+		isJSON: strings.ToLower(filepath.Ext(d.source)) == ".json",
+		commit: func() { d.modTime, d.size = info.ModTime(), info.Size() },
+	}, nil
+}
+
+// fetch returns the endpoint's content, or nil if it answered 304 Not Modified.
+func (d *DenyList) fetch(ctx context.Context) (*loadedList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if d.etag != "" {
+		req.Header.Set("If-None-Match", d.etag)
+	}
+
+	res, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	etag := res.Header.Get("ETag")
+	return &loadedList{
+		data: data,
+		isJSON: strings.Contains(res.Header.Get("Content-Type"), "json") ||
+			strings.HasSuffix(strings.ToLower(req.URL.Path), ".json"),
+		commit: func() { d.etag = etag },
+	}, nil
+}
+
+func versionOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+func parseAddresses(data []byte, isJSON bool) ([]string, error) {
+	if isJSON {
+		var addresses []string
+		if err := json.Unmarshal(data, &addresses); err != nil {
+			return nil, err
+		}
+		return addresses, nil
+	}
+
+	r := csv.NewReader(strings.NewReader(string(data)))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		addresses = append(addresses, record[0])
+	}
+	return addresses, nil
+}
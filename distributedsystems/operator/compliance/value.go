@@ -0,0 +1,15 @@
+package compliance
+
+import "context"
+
+// ValueThreshold rejects transactions above Max.
+type ValueThreshold struct {
+	Max int64
+}
+
+func (v ValueThreshold) Validate(ctx context.Context, txn Transaction) Result {
+	if txn.Value > v.Max {
+		return Deny(ReasonValueExceeded, "value %d exceeds the limit of %d", txn.Value, v.Max)
+	}
+	return Allow()
+}
@@ -0,0 +1,71 @@
+package compliance
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Velocity rejects a transaction when its sender has already made Limit
+// allowed transactions within the trailing Window.
+type Velocity struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string][]time.Time
+	// lastSweep is when senders with no transactions left in the window were
+	// last dropped from seen, see sweepLocked.
+	lastSweep time.Time
+}
+
+func NewVelocity(limit int, window time.Duration) *Velocity {
+	return &Velocity{
+		limit:  limit,
+		window: window,
+		seen:   make(map[string][]time.Time),
+	}
+}
+
+func (v *Velocity) Validate(ctx context.Context, txn Transaction) Result {
+	from := strings.ToLower(txn.From)
+	now := time.Now()
+	cutoff := now.Add(-v.window)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.sweepLocked(now, cutoff)
+
+	recent := v.seen[from][:0]
+	for _, t := range v.seen[from] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= v.limit {
+		v.seen[from] = recent
+		return Deny(ReasonVelocityExceeded, "%s made %d transactions in the last %s", txn.From, len(recent), v.window)
+	}
+
+	v.seen[from] = append(recent, now)
+	return Allow()
+}
+
+// sweepLocked drops senders whose transactions have all left the window, at
+// most once per window, so seen doesn't grow with every sender ever seen.
+func (v *Velocity) sweepLocked(now, cutoff time.Time) {
+	if now.Sub(v.lastSweep) < v.window {
+		return
+	}
+	v.lastSweep = now
+
+	for from, times := range v.seen {
+		// Timestamps are appended in order, so the last one is the newest.
+		if len(times) == 0 || !times[len(times)-1].After(cutoff) {
+			delete(v.seen, from)
+		}
+	}
+}
@@ -14,9 +14,43 @@ import (
 type Config struct {
 	Environment          string `json:"ENVIRONMENT"`
 	Auth0Domain          string `json:"AUTH0_DOMAIN"`
-	OperatorClientID   string `json:"OPERATOR_AUTH0_CLIENT_ID"`
+	OperatorClientID     string `json:"OPERATOR_AUTH0_CLIENT_ID"`
 	OperatorClientSecret string `json:"OPERATOR_AUTH0_CLIENT_SECRET"`
 	RabbitMQHost         string `json:"RABBITMQ_HOST"`
+	OperatorID           string `json:"OPERATOR_ID"`
+	// OperatorSigningKey is a base64 encoded ed25519 seed used to sign votes
+	OperatorSigningKey string `json:"OPERATOR_SIGNING_KEY"`
+	// Compliance rules, a zero value disables the rule
+	// DenyListSource is a file path or http(s) URL of sanctioned addresses
+	DenyListSource         string `json:"DENY_LIST_SOURCE"`
+	DenyListReloadInterval string `json:"DENY_LIST_RELOAD_INTERVAL"`
+	MaxTransactionValue    int64  `json:"MAX_TRANSACTION_VALUE"`
+	VelocityLimit          int    `json:"VELOCITY_LIMIT"`
+	VelocityWindow         string `json:"VELOCITY_WINDOW"`
+	// OAuth2 client credentials for RabbitMQ. Auth0 at AUTH0_DOMAIN is used
+	// when neither an issuer nor a token URL is set; an issuer alone has its
+	// token endpoint discovered.
+	OAuth2IssuerURL string `json:"OAUTH2_ISSUER_URL"`
+	OAuth2TokenURL  string `json:"OAUTH2_TOKEN_URL"`
+	// OAuth2AuthStyle is client_secret_post, client_secret_basic or private_key_jwt
+	OAuth2AuthStyle string `json:"OAUTH2_AUTH_STYLE"`
+	OAuth2Audience  string `json:"OAUTH2_AUDIENCE"`
+	// OAuth2Scopes is a space separated list of scopes to request
+	OAuth2Scopes string `json:"OAUTH2_SCOPES"`
+	// OAuth2PrivateKey is a PEM encoded key that signs private_key_jwt assertions
+	OAuth2PrivateKey string `json:"OAUTH2_PRIVATE_KEY"`
+	OAuth2KeyID      string `json:"OAUTH2_KEY_ID"`
+	// TracesExporter is none, stdout or otlp
+	TracesExporter string `json:"OTEL_TRACES_EXPORTER"`
+	// OTLPEndpoint is the collector's OTLP/HTTP URL, e.g. http://localhost:4318
+	OTLPEndpoint string `json:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// MetricsAddr is where /metrics is served, defaults to :9090
+	MetricsAddr string `json:"METRICS_ADDR"`
+	// Workers is how many transactions are processed at once, defaults to 4
+	Workers int `json:"WORKERS"`
+	// PrefetchCount is how many unacked transactions RabbitMQ sends ahead,
+	// defaults to twice WORKERS
+	PrefetchCount int `json:"PREFETCH_COUNT"`
 	// Add any other configuration fields you need
 }
 
@@ -65,4 +99,4 @@ func LoadConfig(ctx context.Context) (*Config, error) {
 	return &config, nil
 }
 
-// todo pass in context into loadconfig
\ No newline at end of file
+// todo pass in context into loadconfig
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Verdicts counts processed transactions by verdict (valid or invalid)
+	// and compliance reason code.
+	Verdicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_verdicts_total",
+		Help: "Transactions processed, by verdict and reason.",
+	}, []string{"verdict", "reason"})
+
+	ProcessingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "operator_processing_duration_seconds",
+		Help:    "Time taken to validate a transaction and publish the vote.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+
+	// Failures counts deliveries that did not produce a vote: undecodable
+	// messages and failed publishes.
+	Failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_failures_total",
+		Help: "Deliveries that did not produce a vote, by stage.",
+	}, []string{"stage"})
+
+	// InFlight is how many transactions the worker pool is processing.
+	InFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "operator_transactions_in_flight",
+		Help: "Transactions currently being processed.",
+	})
+)
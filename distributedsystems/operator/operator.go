@@ -2,59 +2,175 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rasha-hantash/golang/distributedsystems/libs/auth"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/logger"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/tracing"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/vote"
+	"github.com/rasha-hantash/golang/distributedsystems/operator/compliance"
 	"github.com/rasha-hantash/golang/distributedsystems/operator/config"
 	"github.com/rasha-hantash/golang/distributedsystems/operator/rabbitmq"
-	"github.com/rasha-hantash/golang/distributedsystems/libs/logger"
 )
 
-
-
-
+// shutdownTimeout bounds how long shutdown waits for the metrics listener and
+// exporters to flush.
+const shutdownTimeout = 10 * time.Second
 
 func main() {
 
-	// load configuration 
+	// load configuration
 	h := &logger.ContextHandler{Handler: slog.NewJSONHandler(os.Stdout, nil)}
 	slog.SetDefault(slog.New(h))
-    ctx := logger.AppendCtx(context.Background(), slog.String("request_id", "req-123"))
+	ctx := logger.AppendCtx(context.Background(), slog.String("service", "operator"))
 
+	if err := run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	cfg , err := config.LoadConfig(ctx)
+// run starts the operator and processes transactions until SIGTERM/SIGINT.
+// Errors are returned rather than exiting so the deferred cleanups, such as
+// flushing the spans that explain a failure, still run.
+func run(ctx context.Context) error {
+	cfg, err := config.LoadConfig(ctx)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName:  "operator",
+		Exporter:     cfg.TracesExporter,
+		OTLPEndpoint: cfg.OTLPEndpoint,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if cfg.OperatorID == "" {
+		return errors.New("OPERATOR_ID must be set")
+	}
+	signingKey, err := vote.ParsePrivateKey(cfg.OperatorSigningKey)
+	if err != nil {
+		return fmt.Errorf("invalid operator signing key: %w", err)
+	}
+	ctx = logger.AppendCtx(ctx, slog.String("operator_id", cfg.OperatorID))
+
+	var velocityWindow time.Duration
+	if cfg.VelocityWindow != "" {
+		velocityWindow, err = time.ParseDuration(cfg.VelocityWindow)
+		if err != nil {
+			return fmt.Errorf("invalid velocity window: %w", err)
+		}
+	}
+	reloadInterval := 30 * time.Second
+	if cfg.DenyListReloadInterval != "" {
+		reloadInterval, err = time.ParseDuration(cfg.DenyListReloadInterval)
+		if err != nil {
+			return fmt.Errorf("invalid deny list reload interval: %w", err)
+		}
+	}
+	validator, err := compliance.New(ctx, compliance.Config{
+		DenyListSource:         cfg.DenyListSource,
+		DenyListReloadInterval: reloadInterval,
+		MaxValue:               cfg.MaxTransactionValue,
+		VelocityLimit:          cfg.VelocityLimit,
+		VelocityWindow:         velocityWindow,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up compliance rules: %w", err)
+	}
+
+	audience := cfg.OAuth2Audience
+	if audience == "" {
+		audience = "rabbitmq"
+	}
+	credentials, err := auth.Settings{
+		Auth0Domain:  cfg.Auth0Domain,
+		IssuerURL:    cfg.OAuth2IssuerURL,
+		TokenURL:     cfg.OAuth2TokenURL,
+		ClientID:     cfg.OperatorClientID,
+		ClientSecret: cfg.OperatorClientSecret,
+		Audience:     audience,
+		Scopes:       cfg.OAuth2Scopes,
+		AuthStyle:    cfg.OAuth2AuthStyle,
+		PrivateKey:   cfg.OAuth2PrivateKey,
+		KeyID:        cfg.OAuth2KeyID,
+	}.ClientCredentials()
+	if err != nil {
+		return fmt.Errorf("invalid OAuth2 client credentials: %w", err)
+	}
+
+	metricsAddr := cfg.MetricsAddr
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	metricsSrv := serveMetrics(ctx, metricsAddr)
+
 	// Initialize RabbitMQ connection
 	rabbitCfg := rabbitmq.RabbitMQConfig{
-		Host: cfg.RabbitMQHost,
-		Port: "5672", // Assuming default port, adjust if needed
-		Auth0Config: auth.Auth0Config{
-			Domain:       cfg.Auth0Domain,
-			ClientID:     cfg.OperatorClientID,
-			ClientSecret: cfg.OperatorClientSecret,
-			Audience:     "rabbitmq",
-		},
-	}
-	rabbitmqSvc := rabbitmq.NewConnection(rabbitCfg)
+		Host:        cfg.RabbitMQHost,
+		Port:        "5672", // Assuming default port, adjust if needed
+		Credentials: credentials,
+		OperatorID:  cfg.OperatorID,
+		SigningKey:  signingKey,
+		Validator:   validator,
+		Workers:     cfg.Workers,
+		Prefetch:    cfg.PrefetchCount,
+	}
+	rabbitmqSvc, err := rabbitmq.NewConnection(ctx, rabbitCfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
 	defer rabbitmqSvc.Close()
 
-	if err := rabbitmqSvc.Setup(); err != nil {
-		log.Fatalf("Failed to set up processor: %v", err)
-	}
+	// SIGTERM/SIGINT cancel the consumer; the connection stays up on ctx until
+	// the workers finish the transactions in hand and the rest are requeued.
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	slog.InfoContext(ctx, "operator service is now listening for messages")
 
-
-	if err := rabbitmqSvc.ProcessTransactions(ctx); err != nil {
+	if err := rabbitmqSvc.ProcessTransactions(sigCtx); err != nil {
 		slog.ErrorContext(ctx, "Error processing transactions", "error", err.Error())
-		log.Fatalf("Error processing transactions: %v", err)
+		return fmt.Errorf("error processing transactions: %w", err)
 	}
+
+	slog.InfoContext(ctx, "shutting down")
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+		slog.WarnContext(ctx, "failed to stop metrics listener", "error", err.Error())
+	}
+	slog.InfoContext(ctx, "shut down")
+	return nil
+}
+
+// serveMetrics exposes Prometheus metrics on addr. The operator keeps
+// processing transactions if the listener fails.
+func serveMetrics(ctx context.Context, addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		slog.InfoContext(ctx, "serving metrics", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.ErrorContext(ctx, "metrics listener stopped", "error", err.Error())
+		}
+	}()
+	return srv
 }
 
 // todo: set up identity pool and iam for the operator service
-// todo: fix logging with slog
\ No newline at end of file
+// todo: fix logging with slog
@@ -2,18 +2,35 @@ package rabbitmq
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
 	"log/slog"
+	"sync"
 
 	"time"
 
-	"github.com/rasha-hantash/golang/distributedsystems/libs/auth"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/auth"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/broker"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/correlation"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/rmqconn"
+	"github.com/rasha-hantash/golang/distributedsystems/libs/vote"
+	"github.com/rasha-hantash/golang/distributedsystems/operator/compliance"
+	"github.com/rasha-hantash/golang/distributedsystems/operator/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/rasha-hantash/golang/distributedsystems/operator/rabbitmq")
+
+// exchangeName is the fanout exchange the dispatcher broadcasts transactions
+// on.
+const exchangeName = "transaction_requests"
+
 type TransactionRequest struct {
 	TransactionID string `json:"transaction_id"`
 	TxnHash       string `json:"txn_hash"`
@@ -25,146 +42,340 @@ type TransactionRequest struct {
 type TransactionResponse struct {
 	TransactionID string `json:"transaction_id"`
 	IsValid       bool   `json:"is_valid"`
+	OperatorID    string `json:"operator_id"`
+	// Reason is the compliance.ReasonCode behind the verdict.
+	Reason string `json:"reason"`
+	// ListVersion is the version of the deny list the verdict was made against.
+	ListVersion string `json:"list_version,omitempty"`
+	// Signature is the operator's ed25519 signature over vote.Payload.
+	Signature []byte `json:"signature"`
 }
 
+// DefaultWorkers is how many transactions an operator processes at once when
+// not configured.
+const DefaultWorkers = 4
 
-type RabbitMQService struct {
-	rabbitMQConn *amqp.Connection
-	rabbitMQChan   *amqp.Channel
+// publishAttempts is how many times a vote is published before giving up,
+// waiting publishBackoff, doubled each time, in between. The dispatcher only
+// waits its response window for votes, so retrying longer is pointless.
+const (
+	publishAttempts = 3
+	publishBackoff  = 200 * time.Millisecond
+)
 
+type RabbitMQService struct {
+	broker     broker.Broker
+	operatorID string
+	signingKey ed25519.PrivateKey
+	validator  compliance.Validator
+	workers    int
+	prefetch   int
 }
 
 type RabbitMQConfig struct {
-	Host     string
-	Port     string
-	Auth0Config auth.Auth0Config
+	Host string
+	Port string
+	// Credentials are exchanged for the OAuth2 token used to log in to
+	// RabbitMQ.
+	Credentials auth.ClientCredentialsConfig
+	OperatorID  string
+	SigningKey  ed25519.PrivateKey
+	Validator   compliance.Validator
+	// Workers is how many transactions are processed at once. Defaults to
+	// DefaultWorkers.
+	Workers int
+	// Prefetch is how many unacked transactions RabbitMQ sends ahead of the
+	// workers. Defaults to twice Workers so every worker has the next one
+	// ready.
+	Prefetch int
 }
 
-
-
-func NewConnection(rabbitmqCfg RabbitMQConfig) (*RabbitMQService) {
-	auth0Token, err := auth.GetAuth0Token(rabbitmqCfg.Auth0Config)
-	failOnError(err, "Error getting token")
-
+// NewConnection connects to RabbitMQ and declares the transaction_requests
+// exchange. Errors are *rmqconn.ConnectError and match rmqconn.ErrAuth,
+// ErrDial or ErrTopology.
+func NewConnection(ctx context.Context, rabbitmqCfg RabbitMQConfig) (*RabbitMQService, error) {
 	rabbitmqURL := fmt.Sprintf("amqp://%s:5672", rabbitmqCfg.Host)
+	tokens := auth.NewClientCredentialsTokenSource(rabbitmqCfg.Credentials)
+
+	conn, err := rmqconn.New(ctx, rmqconn.Config{
+		// The token source hands out a cached token that is refreshed before
+		// it expires, so reconnects never dial with an expired one.
+		Dial: func() (*amqp.Connection, error) {
+			accessToken, err := tokens.Token()
+			if err != nil {
+				return nil, rmqconn.AuthFailed(fmt.Errorf("error getting token: %w", err))
+			}
+			// Create a custom dialer that includes the OAuth2 token
+			return amqp.DialConfig(rabbitmqURL, amqp.Config{
+				Heartbeat: 10 * time.Second,
+				Locale:    "en_US",
+				SASL:      []amqp.Authentication{&amqp.PlainAuth{Username: "", Password: accessToken}},
+			})
+		},
+		Setup: setup,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Create a custom dialer that includes the OAuth2 token
-	conn, err := amqp.DialConfig(rabbitmqURL, amqp.Config{
-		Heartbeat: 10 * time.Second,
-		Locale:    "en_US",
-		SASL:      []amqp.Authentication{&amqp.PlainAuth{Username: "", Password: auth0Token}},
+	// Hand refreshed tokens to the broker so it doesn't close the connection
+	// when the token it was opened with expires.
+	go tokens.AutoRefresh(ctx, func(token string) {
+		if err := conn.UpdateSecret(token, "token refresh"); err != nil {
+			slog.WarnContext(ctx, "failed to update rabbitmq secret", "error", err.Error())
+		}
 	})
-	failOnError(err, "Failed to open a connection")
-	ch, err := conn.Channel()
-	failOnError(err, "Failed to open a channel")
+
+	// Every worker publishes its vote, so give each a channel.
+	return NewService(broker.NewAMQP(conn, workers(rabbitmqCfg)), rabbitmqCfg), nil
+}
+
+// NewService returns a service that consumes transactions from b, e.g. a
+// broker.Memory in tests. Host, Port and Credentials are not used.
+func NewService(b broker.Broker, rabbitmqCfg RabbitMQConfig) *RabbitMQService {
+	prefetch := rabbitmqCfg.Prefetch
+	if prefetch <= 0 {
+		prefetch = 2 * workers(rabbitmqCfg)
+	}
 
 	return &RabbitMQService{
-		rabbitMQConn: conn,
-		rabbitMQChan:   ch,
+		broker:     b,
+		operatorID: rabbitmqCfg.OperatorID,
+		signingKey: rabbitmqCfg.SigningKey,
+		validator:  rabbitmqCfg.Validator,
+		workers:    workers(rabbitmqCfg),
+		prefetch:   prefetch,
 	}
+}
 
+func workers(rabbitmqCfg RabbitMQConfig) int {
+	if rabbitmqCfg.Workers <= 0 {
+		return DefaultWorkers
+	}
+	return rabbitmqCfg.Workers
 }
 
-func (rmq *RabbitMQService) Setup() error {
-	q, err := rmq.rabbitMQChan.QueueDeclare(
-		"",
-		false,
-		false,
+// setup declares the transaction_requests exchange. It runs again on the new
+// channel after every reconnect.
+func setup(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		exchangeName,
+		"fanout",
 		true,
 		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to declare a queue: %w", err)
-	}
-
-	// todo check to see if i can create the same transaction_requests exchange 
-	// and txn_{id} that dispatcher creates
-	err = rmq.rabbitMQChan.QueueBind(
-		q.Name,
-		"",
-		"transaction_requests",
+		false,
 		false,
 		nil,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to bind a queue: %w", err)
+		return fmt.Errorf("failed to declare an exchange: %w", err)
 	}
-
 	return nil
 }
 
+// ProcessTransactions consumes transactions until ctx is cancelled. Each
+// subscription binds a fresh queue to the exchange; when the connection drops
+// it subscribes again once the broker is back, so transactions broadcast while
+// the operator was disconnected are not delivered to it.
+//
+// Up to Workers transactions are processed at once, each acked as soon as its
+// vote is sent, so one slow validation doesn't hold up the rest. Cancelling
+// ctx cancels the subscription: the transactions being processed are finished
+// and acked, and deliveries the broker had already sent are requeued.
 func (rmq *RabbitMQService) ProcessTransactions(ctx context.Context) error {
-	// how 
-	msgs, err := rmq.rabbitMQChan.ConsumeWithContext(
-		ctx,
-		"",
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to register a consumer: %w", err)
+	for {
+		msgs, err := rmq.broker.Subscribe(ctx, exchangeName, rmq.operatorID, rmq.prefetch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, rmqconn.ErrNotConnected) {
+				return fmt.Errorf("failed to get a channel: %w", err)
+			}
+			// The channel may have died before the supervisor noticed; give
+			// it a moment to reconnect.
+			slog.WarnContext(ctx, "failed to subscribe to transactions, retrying", "error", err.Error())
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		rmq.consume(ctx, msgs)
+		if ctx.Err() != nil {
+			slog.InfoContext(ctx, "stopped consuming transactions")
+			return nil
+		}
+		slog.WarnContext(ctx, "consumer stopped, waiting for rabbitmq to reconnect")
 	}
+}
 
-	for d := range msgs {
-		rmq.processTransaction(ctx, d)
-		d.Ack(false)
+// consume processes deliveries on the worker pool until the subscription ends
+// and every worker has finished the transaction in hand.
+func (rmq *RabbitMQService) consume(ctx context.Context, msgs <-chan broker.Delivery) {
+	// Processing runs on a context that isn't cancelled on shutdown so the
+	// votes for the transactions in hand still get published.
+	processCtx := context.WithoutCancel(ctx)
+
+	var wg sync.WaitGroup
+	for range rmq.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range msgs {
+				metrics.InFlight.Inc()
+				rmq.settle(processCtx, d, rmq.processTransaction(processCtx, d))
+				metrics.InFlight.Dec()
+			}
+		}()
 	}
+	wg.Wait()
+}
 
-	return nil
+// settle acks a delivery once its vote is sent. When the vote couldn't be
+// published it is discarded rather than requeued: validating it again would
+// count its sender twice towards velocity limits, and by the time it came
+// back the dispatcher would have stopped waiting for the vote.
+func (rmq *RabbitMQService) settle(ctx context.Context, d broker.Delivery, processErr error) {
+	if processErr != nil {
+		if err := d.Nack(false); err != nil {
+			slog.WarnContext(ctx, "failed to discard transaction", "error", err.Error())
+		}
+		return
+	}
+	if err := d.Ack(); err != nil {
+		// The channel is gone; the broker requeues the delivery.
+		slog.WarnContext(ctx, "failed to ack transaction", "error", err.Error())
+	}
 }
 
-func (rmq *RabbitMQService) processTransaction(ctx context.Context, d amqp.Delivery) {
+// processTransaction validates a transaction and publishes the vote, retrying
+// the publish publishAttempts times. It only fails when the vote couldn't be
+// published; undecodable transactions are logged and dropped since
+// redelivering them won't help.
+func (rmq *RabbitMQService) processTransaction(ctx context.Context, d broker.Delivery) error {
+	start := time.Now()
+	defer func() {
+		metrics.ProcessingDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	ctx = correlation.Extract(ctx, d.Headers)
+	ctx, span := tracer.Start(ctx, "transaction_requests process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", exchangeName),
+			attribute.String("operator.id", rmq.operatorID),
+		),
+	)
+	defer span.End()
+
 	var txnRequest TransactionRequest
 	if err := json.Unmarshal(d.Body, &txnRequest); err != nil {
 		slog.ErrorContext(ctx, "error decoding transaction", "error", err.Error())
-		return
+		metrics.Failures.WithLabelValues("decode").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error decoding transaction")
+		return nil
 	}
+	span.SetAttributes(attribute.String("transaction.id", txnRequest.TransactionID))
 
-	// Simulate processing time
-	time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
-
-	// Simulate validation (replace with actual validation logic)
-	isValid := rand.Float32() < 0.9
+	result := rmq.validator.Validate(ctx, compliance.Transaction{
+		TxnHash: txnRequest.TxnHash,
+		From:    txnRequest.From,
+		To:      txnRequest.To,
+		Value:   txnRequest.Value,
+	})
+	isValid := result.Valid
+	verdict := "invalid"
+	if isValid {
+		verdict = "valid"
+	}
+	metrics.Verdicts.WithLabelValues(verdict, string(result.Reason)).Inc()
+	span.SetAttributes(
+		attribute.Bool("vote.is_valid", isValid),
+		attribute.String("vote.reason", string(result.Reason)),
+		attribute.String("compliance.list_version", result.ListVersion),
+	)
 
 	slog.InfoContext(ctx, "Processing transaction",
 		"transaction_id", txnRequest.TransactionID,
 		"is_valid", isValid,
+		"reason", result.Reason,
+		"detail", result.Detail,
+		"list_version", result.ListVersion,
 	)
 
 	response := TransactionResponse{
 		TransactionID: txnRequest.TransactionID,
 		IsValid:       isValid,
+		OperatorID:    rmq.operatorID,
+		Reason:        string(result.Reason),
+		ListVersion:   result.ListVersion,
 	}
+	response.Signature = vote.Sign(rmq.signingKey, vote.Vote{
+		OperatorID:    response.OperatorID,
+		TransactionID: response.TransactionID,
+		IsValid:       response.IsValid,
+		Reason:        response.Reason,
+		ListVersion:   response.ListVersion,
+	})
 
-	if err := rmq.publishResponse(ctx, response); err != nil {
+	if err := rmq.publishWithRetry(ctx, d, response); err != nil {
 		slog.ErrorContext(ctx, "error publishing response", "error", err.Error())
-	} else {
-		slog.InfoContext(ctx, "published response for transaction", "transaction_id", txnRequest.TransactionID)
+		metrics.Failures.WithLabelValues("publish").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error publishing response")
+		return err
 	}
+	slog.InfoContext(ctx, "published response for transaction", "transaction_id", txnRequest.TransactionID)
+	return nil
 }
 
-func (rmq *RabbitMQService) publishResponse(ctx context.Context, response TransactionResponse) error {
+// publishWithRetry publishes the vote, backing off between failed attempts.
+// The vote is signed once, so retries don't run the validator again.
+func (rmq *RabbitMQService) publishWithRetry(ctx context.Context, d broker.Delivery, response TransactionResponse) error {
+	backoff := publishBackoff
+	for attempt := 1; ; attempt++ {
+		err := rmq.publishResponse(ctx, d, response)
+		if err == nil || attempt == publishAttempts {
+			return err
+		}
+		slog.WarnContext(ctx, "failed to publish response, retrying", "attempt", attempt, "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// publishResponse sends the vote to the queue the transaction names in its
+// reply-to, correlated by transaction ID. Dispatchers that don't set a reply-to
+// collect votes on a queue named after the transaction.
+func (rmq *RabbitMQService) publishResponse(ctx context.Context, d broker.Delivery, response TransactionResponse) error {
 	responseBody, err := json.Marshal(response)
 	if err != nil {
 		return fmt.Errorf("error encoding response: %w", err)
 	}
 
-	err = rmq.rabbitMQChan.PublishWithContext(
-		ctx,
-		"",
-		response.TransactionID,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        responseBody,
-		},
-	)
+	replyTo, correlationID := d.ReplyTo, d.CorrelationID
+	if replyTo == "" {
+		replyTo = response.TransactionID
+	}
+	if correlationID == "" {
+		correlationID = response.TransactionID
+	}
+
+	err = rmq.broker.Send(ctx, replyTo, broker.Message{
+		ContentType:   "application/json",
+		Headers:       correlation.Inject(ctx, nil),
+		CorrelationID: correlationID,
+		Body:          responseBody,
+	})
 	if err != nil {
 		return fmt.Errorf("error publishing response: %w", err)
 	}
@@ -173,13 +384,5 @@ func (rmq *RabbitMQService) publishResponse(ctx context.Context, response Transa
 }
 
 func (rmq *RabbitMQService) Close() {
-	rmq.rabbitMQChan.Close()
-	rmq.rabbitMQConn.Close()
-}
-
-
-func failOnError(err error, msg string) {
-	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
-	}
+	rmq.broker.Close()
 }
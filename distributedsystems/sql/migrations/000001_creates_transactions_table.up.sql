@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS transactions (
+    id VARCHAR(64) PRIMARY KEY,
+    txn_hash VARCHAR(128) NOT NULL,
+    from_address VARCHAR(64) NOT NULL,
+    to_address VARCHAR(64) NOT NULL,
+    value BIGINT NOT NULL,
+    quorum_policy VARCHAR(64) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    is_compliant BOOLEAN,
+    decided_by VARCHAR(20),
+    valid_votes INTEGER NOT NULL DEFAULT 0,
+    invalid_votes INTEGER NOT NULL DEFAULT 0,
+    missing_votes INTEGER NOT NULL DEFAULT 0,
+    elapsed_ms BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS transactions_txn_hash_idx ON transactions (txn_hash);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS transaction_votes (
+    id SERIAL PRIMARY KEY,
+    transaction_id VARCHAR(64) NOT NULL REFERENCES transactions (id) ON DELETE CASCADE,
+    operator_id VARCHAR(64) NOT NULL,
+    is_valid BOOLEAN NOT NULL,
+    signature BYTEA,
+    received_at TIMESTAMP NOT NULL,
+    UNIQUE (transaction_id, operator_id)
+);
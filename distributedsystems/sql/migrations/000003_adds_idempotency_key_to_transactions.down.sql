@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS transactions_idempotency_key_idx;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS idempotency_key;
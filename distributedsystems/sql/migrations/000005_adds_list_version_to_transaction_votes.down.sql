@@ -0,0 +1 @@
+ALTER TABLE transaction_votes DROP COLUMN IF EXISTS list_version;
@@ -0,0 +1 @@
+ALTER TABLE transaction_votes ADD COLUMN IF NOT EXISTS list_version VARCHAR(64);